@@ -0,0 +1,147 @@
+package setcd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/helloyi/setcd/dir"
+)
+
+// archivePrefix returns a new key prefix of the archive of the tag of rdir.
+//
+// A prefix ends with "//" which is in no dir, so no prefix is a prefix of
+// another one.
+func archivePrefix(rdir, tag string, rev int64) string {
+	base := dir.Join(Config.MD.ArchiveDir, rdir)
+	return fmt.Sprintf("%s%s@%d.%d//", base, tag, rev, time.Now().UnixNano())
+}
+
+// archive copies the data and metadata of the dir at rev into a new archive,
+// and returns the prefix of the archive.
+func (c *Client) archive(tag string, rev int64) (string, error) {
+	kvs, md, err := c.readDir(rev)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := archivePrefix(c.rdir, tag, rev)
+	ops := make([]Op, 0, len(kvs)+len(md))
+	for _, kv := range kvs {
+		ops = append(ops, opPut(archiveKey(prefix, kv.Key), kv.Value))
+	}
+	for key, val := range md {
+		ops = append(ops, opPut(archiveKey(prefix, key), val))
+	}
+
+	// the archive is not read before the tag is put, so it is written in chunks
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > Config.MaxTxnOps {
+			n = Config.MaxTxnOps
+		}
+		if _, err := c.backend.Txn(c.ctx, nil, ops[:n], nil); err != nil {
+			c.dropArchive(prefix)
+			return "", err
+		}
+		ops = ops[n:]
+	}
+	return prefix, nil
+}
+
+// dropArchive deletes the archive of prefix.
+func (c *Client) dropArchive(prefix string) error {
+	_, err := c.backend.Do(c.ctx, opDeletePrefix(prefix))
+	return err
+}
+
+func archiveKey(prefix, key string) string {
+	return prefix + strings.TrimPrefix(key, "/")
+}
+
+// tagged returns the client of the dir at the revision of the tag. An archived
+// tag is read from its archive once the revision has been compacted.
+func (c *Client) tagged(tag string) (*Client, error) {
+	t, err := c.mdGetTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	if t.Archive == "" {
+		return c.atRev(t.Revision), nil
+	}
+
+	op := opGet(c.rdir, t.Revision)
+	op.CountOnly = true
+	if _, err := c.backend.Do(c.ctx, op); err != ErrCompacted {
+		if err != nil {
+			return nil, err
+		}
+		return c.atRev(t.Revision), nil
+	}
+
+	ac := c.atRev(0)
+	ac.backend = &archiveBackend{Backend: c.backend, prefix: t.Archive}
+	ac.idxCache = newIdxCache()
+	return ac, nil
+}
+
+// archiveBackend is a read-only Backend of an archive, the keys are read at
+// the current revision of the archive.
+type archiveBackend struct {
+	Backend
+	prefix string
+}
+
+// Do ...
+func (b *archiveBackend) Do(ctx context.Context, op Op) (*Response, error) {
+	resp, err := b.Txn(ctx, nil, []Op{op}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Responses[0], nil
+}
+
+// Txn ...
+func (b *archiveBackend) Txn(ctx context.Context, cmps []Cmp, thenOps, elseOps []Op) (*TxnResponse, error) {
+	if len(cmps) != 0 {
+		return nil, fmt.Errorf("%s: compare an archive", ErrInvalidOperation)
+	}
+
+	ops := make([]Op, len(thenOps))
+	for i, op := range thenOps {
+		if op.Type != OpGet {
+			return nil, fmt.Errorf("%s: write an archive", ErrInvalidOperation)
+		}
+		op.Key = archiveKey(b.prefix, op.Key)
+		switch op.End {
+		case "":
+		case "\x00":
+			op.End = prefixEnd(b.prefix)
+		default:
+			op.End = archiveKey(b.prefix, op.End)
+		}
+		op.Rev = 0
+		ops[i] = op
+	}
+
+	resp, err := b.Backend.Txn(ctx, nil, ops, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resp.Responses {
+		for _, kv := range r.Kvs {
+			kv.Key = "/" + strings.TrimPrefix(kv.Key, b.prefix)
+		}
+	}
+	return resp, nil
+}
+
+// Watch ...
+func (b *archiveBackend) Watch(ctx context.Context, key, end string, rev int64) <-chan WatchResponse {
+	ch := make(chan WatchResponse, 1)
+	ch <- WatchResponse{Err: fmt.Errorf("%s: watch an archive", ErrInvalidOperation)}
+	close(ch)
+	return ch
+}
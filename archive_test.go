@@ -0,0 +1,126 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Archive", func() {
+	var (
+		ctx     = context.Background()
+		backend setcd.Backend
+		cli     *setcd.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		backend = setcd.NewMemoryBackend()
+		cli, err = setcd.NewWithBackend(backend, ctx, "/Archive")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(map[string]interface{}{
+			"scheme": "http",
+			"hosts":  []string{"alpha", "beta"},
+		}, setcd.WithTag("v1"), setcd.WithTagArchive())
+		Expect(err).NotTo(HaveOccurred())
+		err = cli.Put(map[string]interface{}{"scheme": "https"}, setcd.WithTag("v2"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	compact := func() {
+		rev, err := backend.Rev(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backend.Compact(ctx, rev)).To(Succeed())
+	}
+
+	archived := func() int64 {
+		end := setcd.Config.MD.ArchiveDir + "0"
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: setcd.Config.MD.ArchiveDir, End: end, CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		return resp.Count
+	}
+
+	Specify("reads fall back to the archive", func() {
+		compact()
+
+		Expect(cli.Get(setcd.WithTag("v1"))).To(Equal(map[string]interface{}{
+			"scheme": "http",
+			"hosts":  []interface{}{"alpha", "beta"},
+		}))
+		_, err := cli.Get(setcd.WithTag("v2"))
+		Expect(err).To(Equal(setcd.ErrCompacted))
+
+		hc, err := cli.ShadowClone("hosts")
+		Expect(err).NotTo(HaveOccurred())
+		var hosts []interface{}
+		err = hc.DoSlice(func(i int, v interface{}) bool {
+			hosts = append(hosts, v)
+			return true
+		}, setcd.WithTag("v1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts).To(Equal([]interface{}{"alpha", "beta"}))
+
+		Expect(cli.Rollback("v1")).To(Succeed())
+		Expect(cli.Get()).To(Equal(map[string]interface{}{
+			"scheme": "http",
+			"hosts":  []interface{}{"alpha", "beta"},
+		}))
+	})
+
+	Specify("moved and deleted with the tag", func() {
+		Expect(archived()).NotTo(BeZero())
+
+		Expect(cli.MoveTag("v1", 0)).To(Succeed())
+		compact()
+		Expect(cli.GetMap(setcd.WithTag("v1"))).To(HaveKeyWithValue("scheme", "https"))
+
+		Expect(cli.DeleteTag("v1")).To(Succeed())
+		Expect(archived()).To(BeZero())
+	})
+
+	Specify("moved only to a readable revision", func() {
+		tags, err := cli.Tags()
+		Expect(err).NotTo(HaveOccurred())
+		rev, err := backend.Rev(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cli.MoveTag("v2", rev+1)).To(MatchError(ContainSubstring(setcd.ErrFutureRev.Error())))
+
+		compact()
+		Expect(cli.MoveTag("v2", tags[0].Revision)).To(MatchError(ContainSubstring(setcd.ErrCompacted.Error())))
+		Expect(cli.MoveTag("v1", tags[0].Revision)).NotTo(Succeed())
+		Expect(cli.GetMap(setcd.WithTag("v1"))).To(HaveKeyWithValue("scheme", "http"))
+
+		Expect(cli.MoveTag("v2", 0)).To(Succeed())
+		Expect(cli.GetMap(setcd.WithTag("v2"))).To(HaveKeyWithValue("scheme", "https"))
+	})
+
+	Specify("prune", func() {
+		for _, name := range []string{"v3", "v4"} {
+			Expect(cli.CreateTag(name, setcd.WithTagArchive())).To(Succeed())
+		}
+
+		pruned, err := cli.PruneTags(setcd.RetentionPolicy{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pruned).To(BeEmpty())
+
+		pruned, err = cli.PruneTags(setcd.RetentionPolicy{KeepLast: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pruned).To(HaveLen(2))
+		Expect(pruned[0].Name).To(Equal("v1"))
+		Expect(pruned[1].Name).To(Equal("v2"))
+
+		tags, err := cli.Tags()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(HaveLen(2))
+		Expect(tags[0].Name).To(Equal("v3"))
+	})
+})
@@ -0,0 +1,194 @@
+package setcd
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Backend is the key-value storage that a Client and its STM are built on.
+//
+// A Backend must be a multi-version store: every committed write (a single
+// put/delete or a whole Txn) creates a new revision, and reads can be served
+// at any revision that has not been compacted yet.
+type Backend interface {
+	// Do executes a single get, put or delete operation.
+	Do(ctx context.Context, op Op) (*Response, error)
+
+	// Txn executes thenOps atomically when all cmps hold, elseOps otherwise.
+	Txn(ctx context.Context, cmps []Cmp, thenOps, elseOps []Op) (*TxnResponse, error)
+
+	// Watch watches the key range [key, end) from the revision rev, or from
+	// the next revision if rev is 0. An empty end watches the single key.
+	// The returned channel is closed when ctx is done.
+	Watch(ctx context.Context, key, end string, rev int64) <-chan WatchResponse
+
+	// Rev returns the current revision of the store.
+	Rev(ctx context.Context) (int64, error)
+
+	// Compact discards the revisions before rev, the reads and watches
+	// before rev fail with ErrCompacted afterwards.
+	Compact(ctx context.Context, rev int64) error
+
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// KeyValue is a key-value pair with its revisions.
+type KeyValue struct {
+	Key            string
+	Value          string
+	CreateRevision int64 // revision of last creation
+	ModRevision    int64 // revision of last modification
+	Version        int64 // number of modifications since creation
+}
+
+// OpType is the type of an Op.
+type OpType int
+
+const (
+	OpGet OpType = iota
+	OpPut
+	OpDelete
+)
+
+// Op is an operation on a Backend.
+//
+// Get and delete operations work on the key range [Key, End), or on the single
+// Key if End is empty. An End of "\x00" means all keys from Key.
+type Op struct {
+	Type  OpType
+	Key   string
+	End   string
+	Value string // value of put
+
+	Rev       int64 // revision of get, 0 means the current revision
+	Limit     int64 // max number of keys of get, 0 means no limit
+	KeysOnly  bool  // get keys without values
+	CountOnly bool  // get count of keys only
+}
+
+// Response is the response of an Op.
+type Response struct {
+	Revision int64       // store revision when the op is executed
+	Kvs      []*KeyValue // key-value pairs of get, sorted by key ascending
+	Count    int64       // count of keys in the range of get
+	More     bool        // there are more keys in the range of get
+	Deleted  int64       // number of deleted keys
+}
+
+// CmpTarget is the target of a Cmp.
+type CmpTarget int
+
+const (
+	CmpValue CmpTarget = iota
+	CmpVersion
+	CmpCreateRevision
+	CmpModRevision
+)
+
+// Cmp is a condition of a Txn.
+//
+// It compares the Target of the keys in [Key, End), or of the single Key if
+// End is empty, against Value or Rev with Result, which is one of "=", "!=",
+// "<" and ">". A missing key has zero version and revisions, and any value
+// comparison on it fails.
+type Cmp struct {
+	Key    string
+	End    string
+	Target CmpTarget
+	Result string
+	Value  string // compared value of CmpValue
+	Rev    int64  // compared number of CmpVersion and the revision targets
+}
+
+// TxnResponse is the response of a Txn.
+type TxnResponse struct {
+	Revision  int64       // store revision after the txn
+	Succeeded bool        // all cmps hold
+	Responses []*Response // responses of the executed ops
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event is a change of a key.
+type Event struct {
+	Type EventType
+	Kv   *KeyValue // the key-value pair after the change; a delete has only Key and ModRevision
+}
+
+// WatchResponse is a batch of events of a Watch.
+type WatchResponse struct {
+	Revision        int64    // store revision of the response
+	Events          []*Event // events sorted by revision
+	CompactRevision int64    // set when the watch revision has been compacted
+	Err             error    // set when the watch is broken; the channel is closed afterwards
+}
+
+// -------------------------------------------------------------------------------------
+// -----------------------------op helpers----------------------------------------------
+// -------------------------------------------------------------------------------------
+
+// opGet gets a single key at revision rev.
+func opGet(key string, rev int64) Op {
+	return Op{Type: OpGet, Key: key, Rev: rev}
+}
+
+// opGetPrefix gets all keys with prefix at revision rev.
+func opGetPrefix(prefix string, rev int64) Op {
+	return Op{Type: OpGet, Key: prefix, End: prefixEnd(prefix), Rev: rev}
+}
+
+// opPut puts a single key.
+func opPut(key, val string) Op {
+	return Op{Type: OpPut, Key: key, Value: val}
+}
+
+// opDelete deletes a single key.
+func opDelete(key string) Op {
+	return Op{Type: OpDelete, Key: key}
+}
+
+// opDeletePrefix deletes all keys with prefix.
+func opDeletePrefix(prefix string) Op {
+	return Op{Type: OpDelete, Key: prefix, End: prefixEnd(prefix)}
+}
+
+// cmpValue compares the value of key.
+func cmpValue(key, result, val string) Cmp {
+	return Cmp{Key: key, Target: CmpValue, Result: result, Value: val}
+}
+
+// cmpModRev compares the mod revision of key.
+func cmpModRev(key, result string, rev int64) Cmp {
+	return Cmp{Key: key, Target: CmpModRevision, Result: result, Rev: rev}
+}
+
+// prefixEnd returns the range end of all keys with prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// all bytes are 0xff, the range is all keys from prefix
+	return "\x00"
+}
+
+// inRange reports whether key is in the range [start, end) of an op or cmp.
+func inRange(key, start, end string) bool {
+	switch end {
+	case "":
+		return key == start
+	case "\x00":
+		return key >= start
+	default:
+		return key >= start && key < end
+	}
+}
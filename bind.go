@@ -0,0 +1,144 @@
+package setcd
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+// Binding keeps a struct in sync with a dir.
+//
+// Every change of the dir is decoded into a fresh copy of the struct, which
+// then atomically replaces the current one. A value that fails to decode is
+// reported and skipped, so the last good value stays in place. An empty dir
+// is decoded as the zero struct.
+type Binding struct {
+	typ      reflect.Type // bound struct type
+	path     string       // bound dir
+	value    atomic.Value // pointer of the current struct
+	onChange func(old, new interface{})
+	onError  func(error)
+
+	mu  sync.Mutex
+	err error // last error, nil after a good change
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Bind decodes the dir into the struct which ptr points to, and keeps it in
+// sync with the dir until the binding is closed. The dir may be empty yet,
+// then the struct is kept as it is until the dir is put.
+//
+// onChange is called with the pointers of the old and new structs after each
+// change, it may be nil. Errors are reported to the function of
+// WithBindErrorFunc.
+func (c *Client) Bind(ptr interface{}, onChange func(old, new interface{}), oos ...OpOption) (*Binding, error) {
+	ptrv := reflect.ValueOf(ptr)
+	if ptrv.Kind() != reflect.Ptr || ptrv.IsNil() || ptrv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s: required a ponter of struct", ErrInvalidArgument)
+	}
+	opt := parseOption(oos)
+
+	// decode the initial value at the revision where the watch starts
+	rev, err := c.backend.Rev(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	val, err := c.atRev(rev).get(opt)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeBound(val, ptr, c.odir); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	b := &Binding{
+		typ:      ptrv.Elem().Type(),
+		path:     c.odir,
+		onChange: onChange,
+		onError:  opt.bindErrFunc,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	b.value.Store(ptr)
+
+	ch := make(chan WatchEvent)
+	go c.watch(ctx, opt, rev, val, ch)
+	go b.run(ch)
+
+	return b, nil
+}
+
+// Load returns the pointer of the current struct.
+//
+// The struct must be treated as read-only, a change replaces the pointer
+// instead of updating the struct.
+func (b *Binding) Load() interface{} {
+	return b.value.Load()
+}
+
+// Err returns the last error of the binding, or nil if the last change is
+// applied.
+func (b *Binding) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Close stops the binding.
+func (b *Binding) Close() {
+	b.cancel()
+	<-b.done
+}
+
+func (b *Binding) run(ch <-chan WatchEvent) {
+	defer close(b.done)
+
+	for ev := range ch {
+		if ev.Err != nil {
+			b.fail(ev.Err)
+			continue
+		}
+
+		nv := reflect.New(b.typ)
+		if err := decodeBound(ev.Value, nv.Interface(), b.path); err != nil {
+			b.fail(fmt.Errorf("decode revision %d: %s", ev.Revision, err))
+			continue
+		}
+
+		old := b.value.Load()
+		b.value.Store(nv.Interface())
+		b.setErr(nil)
+
+		if b.onChange != nil {
+			b.onChange(old, nv.Interface())
+		}
+	}
+}
+
+// decodeBound decodes the value of the bound dir into the struct, the value
+// of an empty dir is nil and leaves the struct as it is.
+func decodeBound(in interface{}, out interface{}, path string) error {
+	if in == nil {
+		return nil
+	}
+	return decodeStruct(in, out, path)
+}
+
+func (b *Binding) fail(err error) {
+	b.setErr(err)
+	if b.onError != nil {
+		b.onError(err)
+	}
+}
+
+func (b *Binding) setErr(err error) {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+}
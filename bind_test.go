@@ -0,0 +1,85 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Bind", func() {
+	type config struct {
+		Host   string
+		Scheme string
+		Port   int
+	}
+
+	var cli *setcd.Client
+
+	BeforeEach(func() {
+		var err error
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), context.Background(), "/Bind")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(map[string]interface{}{"Host": "a", "Scheme": "http"}, setcd.WithLock())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("hot reload", func() {
+		changes := make(chan [2]interface{}, 1)
+		errs := make(chan error, 1)
+
+		var cfg config
+		b, err := cli.Bind(&cfg, func(old, new interface{}) {
+			changes <- [2]interface{}{old, new}
+		}, setcd.WithBindErrorFunc(func(err error) { errs <- err }))
+		Expect(err).NotTo(HaveOccurred())
+		defer b.Close()
+		Expect(cfg).To(Equal(config{Host: "a", Scheme: "http"}))
+
+		err = cli.Put(map[string]interface{}{"Scheme": "https"}, setcd.WithLock())
+		Expect(err).NotTo(HaveOccurred())
+
+		change := <-changes
+		Expect(change[0]).To(Equal(&config{Host: "a", Scheme: "http"}))
+		Expect(change[1]).To(Equal(&config{Host: "a", Scheme: "https"}))
+		Expect(b.Load()).To(Equal(&config{Host: "a", Scheme: "https"}))
+
+		// a bad value is reported, the last good value is kept
+		err = cli.Put(map[string]interface{}{"Port": "http"}, setcd.WithLock())
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(errs).Should(Receive())
+		Expect(b.Err()).To(HaveOccurred())
+		Expect(b.Load()).To(Equal(&config{Host: "a", Scheme: "https"}))
+	})
+
+	Specify("empty dir", func() {
+		ec, err := cli.ShadowClone("empty")
+		Expect(err).NotTo(HaveOccurred())
+
+		changes := make(chan interface{}, 1)
+		var cfg config
+		b, err := ec.Bind(&cfg, func(old, new interface{}) {
+			changes <- new
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer b.Close()
+		Expect(b.Load()).To(Equal(&config{}))
+
+		err = ec.Put(map[string]interface{}{"Host": "b"})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(changes).Should(Receive(Equal(&config{Host: "b"})))
+
+		Expect(ec.Delete()).To(Succeed())
+		Eventually(changes).Should(Receive(Equal(&config{})))
+		Expect(b.Err()).NotTo(HaveOccurred())
+	})
+})
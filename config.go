@@ -1,31 +1,49 @@
 package setcd
 
+import "time"
+
 type config struct {
-	Delimiters []string
-	MD         mdConfig
+	Delimiters     []string
+	MaxTxnOps      int           // max operations per backend txn, the same as '--max-txn-ops' of etcd
+	PendingTimeout time.Duration // age of a stale pending marker, whose staged write the next writer rolls back
+	MD             mdConfig
 }
 
 type mdConfig struct {
-	RootDir      string
-	LenSubDir    string
-	KindSubDir   string
-	TagsSubDir   string
-	IdxesSubDir  string
-	LastIDSubDir string
+	RootDir       string
+	LenSubDir     string
+	KindSubDir    string
+	TypeSubDir    string // type of a scale
+	KeyTypeSubDir string // type of the keys of a map, absent for string keys
+	TagsSubDir    string // tags of the old layout, in the metadata of a first-level dir
+	TagsDir       string
+	ArchiveDir    string
+	TimeDir       string // commit times of the last writes on dirs
+	IdxesSubDir   string
+	LastIDSubDir  string
+	PendingDir    string
 }
 
 var Config config
 
 func init() {
 	Config = config{
-		Delimiters: []string{"{{", "}}"},
+		Delimiters:     []string{"{{", "}}"},
+		MaxTxnOps:      128,
+		PendingTimeout: time.Minute,
 		MD: mdConfig{
-			RootDir:      "/__metadata__",
-			LenSubDir:    "__len__",
-			KindSubDir:   "__kind__",
-			TagsSubDir:   "__tags__",
-			IdxesSubDir:  "__idxes__",
-			LastIDSubDir: "__lastID__",
+			RootDir:       "/__metadata__",
+			LenSubDir:     "__len__",
+			KindSubDir:    "__kind__",
+			TypeSubDir:    "__type__",
+			KeyTypeSubDir: "__keyType__",
+			TagsSubDir:    "__tags__",
+			TagsDir:       "/__metadata__.tags",
+			ArchiveDir:    "/__metadata__.archive",
+			TimeDir:       "/__metadata__.time",
+			IdxesSubDir:   "__idxes__",
+			LastIDSubDir:  "__lastID__",
+			PendingDir:    "/__metadata__.pending",
 		},
 	}
 }
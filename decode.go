@@ -0,0 +1,266 @@
+package setcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/helloyi/setcd/dir"
+)
+
+// Decode decodes the dir into the value which out points to, which may be
+// any type that the dir is decoded as: a scale, a slice, a map or a struct,
+// and pointers of them.
+//
+// The keys of a map are decoded into its key type, or as the key type they
+// were put with for an interface value.
+func (c *Client) Decode(out interface{}, oos ...OpOption) error {
+	outv := reflect.ValueOf(out)
+	if outv.Kind() != reflect.Ptr || outv.IsNil() {
+		return fmt.Errorf("%s: required a non-nil pointer", ErrInvalidArgument)
+	}
+
+	tc := *c
+	tc.typedKeys = true
+	val, err := tc.Get(oos...)
+	if err != nil {
+		return err
+	}
+	return decodeValue(val, outv.Elem(), c.odir)
+}
+
+// GetAs decodes the dir of c as a value of type T, see Client.Decode.
+func GetAs[T any](c *Client, oos ...OpOption) (T, error) {
+	var out T
+	err := c.Decode(&out, oos...)
+	return out, err
+}
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+)
+
+// decodeValue decodes a parsed value into v, a nil value keeps v as it is.
+// path is the dir of the value, which errors are reported on.
+func decodeValue(in interface{}, v reflect.Value, path string) error {
+	if in == nil {
+		return nil
+	}
+	if ok, err := unmarshal(in, v); ok {
+		if err != nil {
+			return fmt.Errorf("%s: '%s'", err, path)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(in, v.Elem(), path)
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			break
+		}
+		v.Set(reflect.ValueOf(in))
+		return nil
+
+	case reflect.Struct:
+		if v.Type() == bigIntType || v.Type() == bigFloatType {
+			return decodeScale(in, v, path)
+		}
+		m, ok := in.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, f := range structFields(v.Type()) {
+			val, ok := lookupField(m, f.name)
+			if !ok {
+				continue
+			}
+			fv, ok := fieldByIndex(v, f.index, true)
+			if !ok {
+				continue
+			}
+			if sv, ok := val.(string); ok && f.asString && indirectType(fv.Type()).Kind() != reflect.String {
+				val = parseScaleString(sv)
+			}
+			if err := decodeValue(val, fv, dir.Join(path, dir.EscapeKey(f.name))); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		m := reflect.ValueOf(in)
+		if m.Kind() != reflect.Map {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), m.Len()))
+		}
+		for iter := m.MapRange(); iter.Next(); {
+			key := iter.Key().Interface()
+			kpath := dir.Join(path, dir.EscapeKey(fmt.Sprint(key)))
+			kv := reflect.New(v.Type().Key()).Elem()
+			if err := decodeKey(key, kv, kpath); err != nil {
+				return err
+			}
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(iter.Value().Interface(), ev, kpath); err != nil {
+				return err
+			}
+			v.SetMapIndex(kv, ev)
+		}
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if bv, ok := in.([]byte); ok && v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), bv...))
+			return nil
+		}
+		s, ok := in.([]interface{})
+		if !ok {
+			break
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(s), len(s)))
+		}
+		for i := 0; i < len(s) && i < v.Len(); i++ {
+			if err := decodeValue(s[i], v.Index(i), dir.Join(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return decodeScale(in, v, path)
+	}
+	return fmt.Errorf("%s: decode '%s' into '%s': '%s'", ErrUnsupportedType, kindOf(in), v.Type(), path)
+}
+
+// decodeKey decodes a map key into v, a string key is parsed by the type of v
+// as a scale of the key type.
+func decodeKey(key interface{}, v reflect.Value, path string) error {
+	sk, ok := key.(string)
+	switch {
+	case ok && v.Kind() != reflect.String && v.Kind() != reflect.Interface &&
+		!reflect.PtrTo(v.Type()).Implements(textUnmarshalerType):
+		typ, _ := mapKeyType(v.Type())
+		parsed, err := parseScale(sk, typ)
+		if err != nil {
+			return fmt.Errorf("%s: decode key '%s' into '%s': '%s'", ErrUnsupportedType, sk, v.Type(), path)
+		}
+		key = parsed
+	case !ok && v.Kind() == reflect.String:
+		key = fmt.Sprint(key)
+	}
+	return decodeValue(key, v, path)
+}
+
+// lookupField returns the value of the field name in m, a case-insensitive
+// match is taken if there is no exact one.
+func lookupField(m map[string]interface{}, name string) (interface{}, bool) {
+	if val, ok := m[name]; ok {
+		return val, true
+	}
+	for key, val := range m {
+		if strings.EqualFold(key, name) {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// parseScaleString parses the value of a field with the string option.
+func parseScaleString(sv string) interface{} {
+	val, err := parseScale(sv, "")
+	if err != nil {
+		return sv
+	}
+	return val
+}
+
+// decodeScale decodes a parsed scale into v, a number is converted into any
+// number type which holds it exactly.
+func decodeScale(in interface{}, v reflect.Value, path string) error {
+	var num *big.Float
+	switch x := in.(type) {
+	case int64:
+		num = new(big.Float).SetInt64(x)
+	case uint64:
+		num = new(big.Float).SetUint64(x)
+	case float64:
+		num = big.NewFloat(x)
+	case *big.Int:
+		num = new(big.Float).SetInt(x)
+	case *big.Float:
+		num = x
+	case json.Number:
+		num, _ = parseBigFloat(x.String(), "")
+	}
+
+	switch {
+	case v.Type() == bigIntType && num != nil && num.IsInt():
+		bi, _ := num.Int(nil)
+		v.Set(reflect.ValueOf(*bi))
+		return nil
+	case v.Type() == bigFloatType && num != nil:
+		v.Set(reflect.ValueOf(*num))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if bv, ok := in.(bool); ok {
+			v.SetBool(bv)
+			return nil
+		}
+	case reflect.String:
+		switch x := in.(type) {
+		case string:
+			v.SetString(x)
+			return nil
+		case json.Number:
+			v.SetString(x.String())
+			return nil
+		case []byte:
+			v.SetString(string(x))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if num != nil && num.IsInt() {
+			if iv, acc := num.Int64(); acc == big.Exact && !v.OverflowInt(iv) {
+				v.SetInt(iv)
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if num != nil && num.IsInt() {
+			if uv, acc := num.Uint64(); acc == big.Exact && !v.OverflowUint(uv) {
+				v.SetUint(uv)
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if num != nil {
+			fv, _ := num.Float64()
+			v.SetFloat(fv)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: decode '%v' into '%s': '%s'", ErrUnsupportedType, in, v.Type(), path)
+}
@@ -0,0 +1,204 @@
+package setcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/helloyi/setcd/dir"
+)
+
+// Diff returns the changes of the dir from the revision of tag from to the
+// revision of tag to, "" is the current revision.
+func (c *Client) Diff(from, to string) ([]Change, error) {
+	var clients [2]*Client
+	for i, tag := range []string{from, to} {
+		clients[i] = c.atRev(0)
+		if tag == "" {
+			continue
+		}
+		rc, err := c.tagged(tag)
+		if err != nil {
+			return nil, err
+		}
+		clients[i] = rc
+	}
+	return c.diff(clients[0], clients[1])
+}
+
+// DiffRevisions returns the changes of the dir from revision from to
+// revision to, 0 is the current revision.
+func (c *Client) DiffRevisions(from, to int64) ([]Change, error) {
+	return c.diff(c.atRev(from), c.atRev(to))
+}
+
+// diff returns the changes of the dir from the reads of old to the reads of new.
+func (c *Client) diff(old, new *Client) ([]Change, error) {
+	ov, err := old.get(parseOption(nil))
+	if err != nil {
+		return nil, err
+	}
+	nv, err := new.get(parseOption(nil))
+	if err != nil {
+		return nil, err
+	}
+	return diffValue(c.odir, ov, nv), nil
+}
+
+// RenderChanges writes the changes to w, one change per line.
+func RenderChanges(w io.Writer, changes []Change) error {
+	for _, change := range changes {
+		if _, err := fmt.Fprintln(w, change.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangeType is the type of a Change.
+type ChangeType int
+
+const (
+	Added ChangeType = iota
+	Removed
+	Modified
+	KindChanged
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	case KindChanged:
+		return "kind-changed"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a change of the value on a dir.
+type Change struct {
+	Path string      // user-facing dir of the value, slice elements are addressed by position
+	Type ChangeType  //
+	Old  interface{} // value before the change, nil if added
+	New  interface{} // value after the change, nil if removed
+}
+
+// String formats the change as a line of a diff, by its type:
+//
+//	~ /app/scheme/: "http" -> "https"
+//	! /app/port/: {"http":80} -> 80
+//	+ /app/tls/: "on"
+//	- /app/host/: "a"
+func (c Change) String() string {
+	switch c.Type {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", c.Path, formatValue(c.New))
+	case Removed:
+		return fmt.Sprintf("- %s: %s", c.Path, formatValue(c.Old))
+	case Modified:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Path, formatValue(c.Old), formatValue(c.New))
+	case KindChanged:
+		return fmt.Sprintf("! %s: %s -> %s", c.Path, formatValue(c.Old), formatValue(c.New))
+	default:
+		return fmt.Sprintf("? %s", c.Path)
+	}
+}
+
+// formatValue formats a parsed value as compact JSON.
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// diffValue returns the changes from old to new on path, sorted by path.
+func diffValue(path string, old, new interface{}) []Change {
+	oldKind := kindOf(old)
+	newKind := kindOf(new)
+
+	switch {
+	case oldKind == Nil && newKind == Nil:
+		return nil
+	case oldKind == Nil:
+		return []Change{{Path: path, Type: Added, New: new}}
+	case newKind == Nil:
+		return []Change{{Path: path, Type: Removed, Old: old}}
+	case oldKind != newKind:
+		return []Change{{Path: path, Type: KindChanged, Old: old, New: new}}
+	}
+
+	switch oldKind {
+	case Map:
+		return diffMap(path, old.(map[string]interface{}), new.(map[string]interface{}))
+	case Slice:
+		return diffSlice(path, old.([]interface{}), new.([]interface{}))
+	default:
+		if reflect.DeepEqual(old, new) {
+			return nil
+		}
+		return []Change{{Path: path, Type: Modified, Old: old, New: new}}
+	}
+}
+
+func diffMap(path string, old, new map[string]interface{}) []Change {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, key := range keys {
+		changes = append(changes, diffValue(dir.Join(path, dir.EscapeKey(key)), old[key], new[key])...)
+	}
+	return changes
+}
+
+func diffSlice(path string, old, new []interface{}) []Change {
+	n := len(old)
+	if len(new) > n {
+		n = len(new)
+	}
+
+	var changes []Change
+	for i := 0; i < n; i++ {
+		var ov, nv interface{}
+		if i < len(old) {
+			ov = old[i]
+		}
+		if i < len(new) {
+			nv = new[i]
+		}
+		changes = append(changes, diffValue(dir.Join(path, strconv.Itoa(i)), ov, nv)...)
+	}
+	return changes
+}
+
+// kindOf returns the kind of a parsed value.
+func kindOf(v interface{}) Kind {
+	switch v.(type) {
+	case nil:
+		return Nil
+	case map[string]interface{}:
+		return Map
+	case []interface{}:
+		return Slice
+	default:
+		return Scale
+	}
+}
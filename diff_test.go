@@ -0,0 +1,75 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Diff", func() {
+	var cli *setcd.Client
+
+	BeforeEach(func() {
+		var err error
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), context.Background(), "/Diff")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(map[string]interface{}{
+			"host":   "a",
+			"scheme": "http",
+			"routes": []interface{}{"api", "web"},
+		}, setcd.WithTag("v1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		rc, err := cli.ShadowClone("routes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.InsertAt(0, "docs")).To(Succeed())
+
+		err = cli.Put(map[string]interface{}{
+			"scheme": "https",
+			"host":   map[string]interface{}{"name": "b"},
+			"tls":    "on",
+		}, setcd.WithTag("v2"), setcd.WithForceKind())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("between tags", func() {
+		changes, err := cli.Diff("v1", "v2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]setcd.Change{
+			{Path: "/Diff/host/", Type: setcd.KindChanged, Old: "a", New: map[string]interface{}{"name": "b"}},
+			{Path: "/Diff/routes/0/", Type: setcd.Modified, Old: "api", New: "docs"},
+			{Path: "/Diff/routes/1/", Type: setcd.Modified, Old: "web", New: "api"},
+			{Path: "/Diff/routes/2/", Type: setcd.Added, New: "web"},
+			{Path: "/Diff/scheme/", Type: setcd.Modified, Old: "http", New: "https"},
+			{Path: "/Diff/tls/", Type: setcd.Added, New: "on"},
+		}))
+
+		var buf bytes.Buffer
+		Expect(setcd.RenderChanges(&buf, changes[:1])).To(Succeed())
+		Expect(buf.String()).To(Equal("! /Diff/host/: \"a\" -> {\"name\":\"b\"}\n"))
+	})
+
+	Specify("to current", func() {
+		Expect(cli.RemoveAt(0)).NotTo(Succeed())
+		tc, err := cli.ShadowClone("tls")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tc.Delete()).To(Succeed())
+
+		changes, err := cli.Diff("v2", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]setcd.Change{
+			{Path: "/Diff/tls/", Type: setcd.Removed, Old: "on"},
+		}))
+		Expect(changes[0].String()).To(Equal("- /Diff/tls/: \"on\""))
+	})
+})
@@ -1,7 +1,9 @@
 package dir
 
 import (
+	"fmt"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -112,3 +114,56 @@ func CParent(dir1, dir2 string) string {
 	}
 	return eqp
 }
+
+// EscapeKey escapes a map key to a branch of a dir, which is reversed by
+// UnescapeKey. '/' and '%' are escaped as %XX, and so are the keys "." and
+// "..", and the leading '_' of a key with the "__" prefix of metadata.
+// The empty key is escaped as "%".
+func EscapeKey(key string) string {
+	switch key {
+	case "":
+		return "%"
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E."
+	}
+	if !strings.ContainsAny(key, "/%") && !strings.HasPrefix(key, "__") {
+		return key
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '%' || (i == 0 && c == '_') {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// UnescapeKey returns the map key of a branch escaped by EscapeKey, a '%'
+// which is not an escape is kept as it is.
+func UnescapeKey(branch string) string {
+	if branch == "%" {
+		return ""
+	}
+	if !strings.Contains(branch, "%") {
+		return branch
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(branch); i++ {
+		if branch[i] == '%' && i+2 < len(branch) {
+			if c, err := strconv.ParseUint(branch[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(branch[i])
+	}
+	return b.String()
+}
@@ -2,6 +2,7 @@ package dir
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -552,3 +553,53 @@ func TestCParent(t *testing.T) {
 		})
 	}
 }
+
+func TestEscapeKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "plain", key: "a.b_c", want: "a.b_c"},
+		{name: "empty", key: "", want: "%"},
+		{name: "dot", key: ".", want: "%2E"},
+		{name: "dot dot", key: "..", want: "%2E."},
+		{name: "slash", key: "a/b/", want: "a%2Fb%2F"},
+		{name: "percent", key: "100%", want: "100%25"},
+		{name: "metadata", key: "__idxes__", want: "%5F_idxes__"},
+		{name: "url", key: "https://x.io/a?b=%20", want: "https:%2F%2Fx.io%2Fa?b=%2520"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EscapeKey(tt.key)
+			if got != tt.want {
+				t.Errorf("EscapeKey() = %v, want %v", got, tt.want)
+			}
+			if strings.Contains(got, "/") || got == "." || got == ".." {
+				t.Errorf("EscapeKey() = %v, not a branch", got)
+			}
+			if key := UnescapeKey(got); key != tt.key {
+				t.Errorf("UnescapeKey() = %v, want %v", key, tt.key)
+			}
+		})
+	}
+}
+
+func TestUnescapeKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		branch string
+		want   string
+	}{
+		{name: "plain", branch: "a", want: "a"},
+		{name: "not escape", branch: "50%", want: "50%"},
+		{name: "not hex", branch: "%zz", want: "%zz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnescapeKey(tt.branch); got != tt.want {
+				t.Errorf("UnescapeKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,4 +17,14 @@ var (
 	ErrUnsupportedDeletion = fmt.Errorf("%s: 'Delete' dir on type", ErrUnsupportedOperaton)
 	ErrUnsupportedDo       = fmt.Errorf("%s: 'Do' dir on type", ErrUnsupportedOperaton)
 	ErrIndexOutOfRange     = fmt.Errorf("slice index out of range")
+
+	ErrClosedBackend = fmt.Errorf("backend is closed")
+	ErrCompacted     = fmt.Errorf("required revision has been compacted")
+	ErrFutureRev     = fmt.Errorf("required revision is a future revision")
+	ErrWatchClosed   = fmt.Errorf("watch channel is closed")
+	ErrTooManyOps    = fmt.Errorf("too many operations in a txn")
+	ErrPendingWrite  = fmt.Errorf("a staged write is pending")
+	ErrLostPending   = fmt.Errorf("pending marker of a staged write is lost")
+	ErrTagNotFound   = fmt.Errorf("tag not found")
+	ErrTagExists     = fmt.Errorf("tag already exists")
 )
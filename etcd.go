@@ -0,0 +1,249 @@
+package setcd
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// etcdBackend is a Backend of etcd v3.
+type etcdBackend struct {
+	client *clientv3.Client
+}
+
+// NewEtcdBackend creates a Backend on an etcd client session.
+func NewEtcdBackend(client *clientv3.Client) Backend {
+	return &etcdBackend{client: client}
+}
+
+// Do ...
+func (b *etcdBackend) Do(ctx context.Context, op Op) (*Response, error) {
+	eop, err := etcdOp(op)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(ctx, eop)
+	if err != nil {
+		return nil, etcdError(err)
+	}
+
+	switch op.Type {
+	case OpGet:
+		return etcdGetResponse(resp.Get()), nil
+	case OpPut:
+		return &Response{Revision: resp.Put().Header.Revision}, nil
+	default:
+		dresp := resp.Del()
+		return &Response{Revision: dresp.Header.Revision, Deleted: dresp.Deleted}, nil
+	}
+}
+
+// Txn ...
+func (b *etcdBackend) Txn(ctx context.Context, cmps []Cmp, thenOps, elseOps []Op) (*TxnResponse, error) {
+	ecmps := make([]clientv3.Cmp, len(cmps))
+	for i, cmp := range cmps {
+		ecmp, err := etcdCmp(cmp)
+		if err != nil {
+			return nil, err
+		}
+		ecmps[i] = ecmp
+	}
+	ethenOps, err := etcdOps(thenOps)
+	if err != nil {
+		return nil, err
+	}
+	eelseOps, err := etcdOps(elseOps)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Txn(ctx).If(ecmps...).Then(ethenOps...).Else(eelseOps...).Commit()
+	if err != nil {
+		return nil, etcdError(err)
+	}
+
+	ret := &TxnResponse{
+		Revision:  resp.Header.Revision,
+		Succeeded: resp.Succeeded,
+		Responses: make([]*Response, len(resp.Responses)),
+	}
+	for i, rop := range resp.Responses {
+		r := &Response{Revision: resp.Header.Revision}
+		if rr := rop.GetResponseRange(); rr != nil {
+			r = etcdGetResponse((*clientv3.GetResponse)(rr))
+		} else if dr := rop.GetResponseDeleteRange(); dr != nil {
+			r.Deleted = dr.Deleted
+		}
+		ret.Responses[i] = r
+	}
+	return ret, nil
+}
+
+// Watch ...
+func (b *etcdBackend) Watch(ctx context.Context, key, end string, rev int64) <-chan WatchResponse {
+	opts := []clientv3.OpOption{}
+	if end != "" {
+		opts = append(opts, clientv3.WithRange(end))
+	}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+
+	wch := b.client.Watch(ctx, key, opts...)
+	ch := make(chan WatchResponse)
+	go func() {
+		defer close(ch)
+		for wresp := range wch {
+			resp := WatchResponse{
+				Revision:        wresp.Header.Revision,
+				CompactRevision: wresp.CompactRevision,
+				Err:             etcdError(wresp.Err()),
+			}
+			for _, ev := range wresp.Events {
+				typ := EventPut
+				if ev.Type == mvccpb.DELETE {
+					typ = EventDelete
+				}
+				resp.Events = append(resp.Events, &Event{Type: typ, Kv: etcdKeyValue(ev.Kv)})
+			}
+
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+				return
+			}
+			if resp.Err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Rev ...
+func (b *etcdBackend) Rev(ctx context.Context) (int64, error) {
+	resp, err := b.client.Get(ctx, "/", clientv3.WithCountOnly())
+	if err != nil {
+		return 0, etcdError(err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// Compact ...
+func (b *etcdBackend) Compact(ctx context.Context, rev int64) error {
+	_, err := b.client.Compact(ctx, rev)
+	return etcdError(err)
+}
+
+// Close shuts down the etcd connections.
+func (b *etcdBackend) Close() error {
+	return b.client.Close()
+}
+
+// etcdError converts the etcd errors of revisions.
+func etcdError(err error) error {
+	switch err {
+	case rpctypes.ErrCompacted:
+		return ErrCompacted
+	case rpctypes.ErrFutureRev:
+		return ErrFutureRev
+	default:
+		return err
+	}
+}
+
+// etcdOp converts op to an etcd op.
+func etcdOp(op Op) (clientv3.Op, error) {
+	var opts []clientv3.OpOption
+	if op.End != "" {
+		opts = append(opts, clientv3.WithRange(op.End))
+	}
+
+	switch op.Type {
+	case OpGet:
+		opts = append(opts, clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		if op.Rev > 0 {
+			opts = append(opts, clientv3.WithRev(op.Rev))
+		}
+		if op.Limit > 0 {
+			opts = append(opts, clientv3.WithLimit(op.Limit))
+		}
+		if op.KeysOnly {
+			opts = append(opts, clientv3.WithKeysOnly())
+		}
+		if op.CountOnly {
+			opts = append(opts, clientv3.WithCountOnly())
+		}
+		return clientv3.OpGet(op.Key, opts...), nil
+	case OpPut:
+		return clientv3.OpPut(op.Key, op.Value), nil
+	case OpDelete:
+		return clientv3.OpDelete(op.Key, opts...), nil
+	default:
+		return clientv3.Op{}, fmt.Errorf("%s: op type '%d'", ErrInvalidArgument, op.Type)
+	}
+}
+
+// etcdOps converts ops to etcd ops.
+func etcdOps(ops []Op) ([]clientv3.Op, error) {
+	eops := make([]clientv3.Op, len(ops))
+	for i, op := range ops {
+		eop, err := etcdOp(op)
+		if err != nil {
+			return nil, err
+		}
+		eops[i] = eop
+	}
+	return eops, nil
+}
+
+// etcdCmp converts cmp to an etcd compare.
+func etcdCmp(cmp Cmp) (clientv3.Cmp, error) {
+	var ecmp clientv3.Cmp
+	switch cmp.Target {
+	case CmpValue:
+		ecmp = clientv3.Compare(clientv3.Value(cmp.Key), cmp.Result, cmp.Value)
+	case CmpVersion:
+		ecmp = clientv3.Compare(clientv3.Version(cmp.Key), cmp.Result, cmp.Rev)
+	case CmpCreateRevision:
+		ecmp = clientv3.Compare(clientv3.CreateRevision(cmp.Key), cmp.Result, cmp.Rev)
+	case CmpModRevision:
+		ecmp = clientv3.Compare(clientv3.ModRevision(cmp.Key), cmp.Result, cmp.Rev)
+	default:
+		return ecmp, fmt.Errorf("%s: cmp target '%d'", ErrInvalidArgument, cmp.Target)
+	}
+
+	if cmp.End != "" {
+		ecmp = ecmp.WithRange(cmp.End)
+	}
+	return ecmp, nil
+}
+
+// etcdGetResponse converts an etcd get response.
+func etcdGetResponse(resp *clientv3.GetResponse) *Response {
+	ret := &Response{
+		Revision: resp.Header.Revision,
+		Kvs:      make([]*KeyValue, len(resp.Kvs)),
+		Count:    resp.Count,
+		More:     resp.More,
+	}
+	for i, kv := range resp.Kvs {
+		ret.Kvs[i] = etcdKeyValue(kv)
+	}
+	return ret
+}
+
+// etcdKeyValue converts an etcd key-value pair.
+func etcdKeyValue(kv *mvccpb.KeyValue) *KeyValue {
+	return &KeyValue{
+		Key:            string(kv.Key),
+		Value:          string(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+	}
+}
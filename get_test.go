@@ -0,0 +1,178 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"encoding/json"
+	"math"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Get", func() {
+	var (
+		backend *countingBackend
+		cli     *setcd.Client
+	)
+
+	data := map[string]interface{}{
+		"name": "gateway",
+		"routes": []interface{}{
+			map[string]interface{}{"path": "/api", "hosts": []interface{}{"a", "b"}},
+			map[string]interface{}{"path": "/web", "hosts": []interface{}{"c"}},
+		},
+		"tls": map[string]interface{}{"cert": "x", "key": map[string]interface{}{"file": "y"}},
+	}
+
+	BeforeEach(func() {
+		var err error
+		backend = &countingBackend{Backend: setcd.NewMemoryBackend()}
+		cli, err = setcd.NewWithBackend(backend, context.Background(), "/Get")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("single round trip", func() {
+		calls := atomic.LoadInt64(&backend.calls)
+		res, err := cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(data))
+		Expect(atomic.LoadInt64(&backend.calls) - calls).To(BeEquivalentTo(1))
+
+		calls = atomic.LoadInt64(&backend.calls)
+		mapv, err := cli.GetMap()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mapv).To(Equal(data))
+		Expect(atomic.LoadInt64(&backend.calls) - calls).To(BeEquivalentTo(1))
+	})
+
+	Specify("decode", func() {
+		type route struct {
+			Path  string   `setcd:"path"`
+			Hosts []string `setcd:"hosts"`
+		}
+		rc, err := cli.ShadowClone("routes")
+		Expect(err).NotTo(HaveOccurred())
+		var routes []route
+		Expect(rc.Decode(&routes)).To(Succeed())
+		Expect(routes).To(Equal([]route{{"/api", []string{"a", "b"}}, {"/web", []string{"c"}}}))
+
+		tc, err := cli.ShadowClone("tls")
+		Expect(err).NotTo(HaveOccurred())
+		tls, err := setcd.GetAs[map[string]interface{}](tc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tls).To(Equal(data["tls"]))
+
+		hc, err := cli.ShadowClone("routes/0/hosts/1")
+		Expect(err).NotTo(HaveOccurred())
+		host, err := setcd.GetAs[**string](hc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(**host).To(Equal("b"))
+
+		// errors name the dir of the value
+		wc, err := rc.ShadowClone("1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = setcd.GetAs[struct {
+			Hosts []int `setcd:"hosts"`
+		}](wc)
+		Expect(err).To(MatchError(ContainSubstring("'/Get/routes/1/hosts/0/'")))
+	})
+
+	Specify("typed scalars", func() {
+		tc, err := cli.ShadowClone("typed")
+		Expect(err).NotTo(HaveOccurred())
+		typed := map[string]interface{}{
+			"port":    int64(8080),
+			"weight":  uint64(1) << 63,
+			"ratio":   0.25,
+			"enabled": true,
+			"version": "123",
+			"flag":    "true",
+		}
+		Expect(tc.Put(typed)).To(Succeed())
+		Expect(tc.Get()).To(Equal(typed))
+
+		// untyped values are parsed by their format
+		_, err = backend.Do(context.Background(), setcd.Op{Type: setcd.OpPut, Key: "/Get/untyped/", Value: "123"})
+		Expect(err).NotTo(HaveOccurred())
+		uc, err := cli.ShadowClone("untyped")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uc.Get()).To(Equal(float64(123)))
+	})
+
+	Specify("big numbers", func() {
+		bc, err := cli.ShadowClone("big")
+		Expect(err).NotTo(HaveOccurred())
+
+		bi, _ := new(big.Int).SetString("1267650600228229401496703205376", 10)
+		bf, _, err := big.ParseFloat("3.14159265358979323846264338327950288", 10, 200, big.ToNearestEven)
+		Expect(err).NotTo(HaveOccurred())
+		nums := map[string]interface{}{
+			"min":     int64(math.MinInt64),
+			"max":     uint64(math.MaxUint64),
+			"account": bi,
+			"pi":      bf,
+			"limit":   json.Number("12345678901234567890.123456789"),
+		}
+		Expect(bc.Put(nums)).To(Succeed())
+		Expect(bc.Get()).To(Equal(nums))
+
+		pc, err := bc.ShadowClone("pi")
+		Expect(err).NotTo(HaveOccurred())
+		pi, err := pc.GetBigFloat()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pi.Prec()).To(BeEquivalentTo(200))
+		Expect(pi.Cmp(bf)).To(BeZero())
+
+		lc, err := bc.ShadowClone("limit")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lc.GetNumber()).To(Equal(json.Number("12345678901234567890.123456789")))
+
+		// untyped integers which float64 does not hold exactly
+		_, err = backend.Do(context.Background(), setcd.Op{Type: setcd.OpPut, Key: "/Get/untyped/", Value: "9007199254740993"})
+		Expect(err).NotTo(HaveOccurred())
+		uc, err := cli.ShadowClone("untyped")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uc.Get()).To(Equal(int64(9007199254740993)))
+		Expect(uc.GetBigInt()).To(Equal(big.NewInt(9007199254740993)))
+	})
+
+	Specify("binary values", func() {
+		bc, err := cli.ShadowClone("bin")
+		Expect(err).NotTo(HaveOccurred())
+		cert := []byte{0x30, 0x82, 0x00, 0xff, '/'}
+		Expect(bc.Put(map[string]interface{}{
+			"cert": cert,
+			"raw":  json.RawMessage(`{"a":1}`),
+		})).To(Succeed())
+		Expect(bc.Get()).To(Equal(map[string]interface{}{"cert": cert, "raw": []byte(`{"a":1}`)}))
+
+		// one key for all bytes
+		resp, err := backend.Do(context.Background(), setcd.Op{Type: setcd.OpGet, Key: "/Get/bin/cert/", End: "/Get/bin/cert0", CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Count).To(BeEquivalentTo(1))
+
+		cc, err := bc.ShadowClone("cert")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.GetBytes()).To(Equal(cert))
+
+		var out struct {
+			Cert []byte          `setcd:"cert"`
+			Raw  json.RawMessage `setcd:"raw"`
+		}
+		Expect(bc.Decode(&out)).To(Succeed())
+		Expect(out.Cert).To(Equal(cert))
+		Expect(out.Raw).To(Equal(json.RawMessage(`{"a":1}`)))
+	})
+})
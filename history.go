@@ -0,0 +1,211 @@
+package setcd
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/helloyi/setcd/dir"
+)
+
+// HistoryEntry is a revision where the dir changed.
+type HistoryEntry struct {
+	Revision int64
+	Time     time.Time // commit time of the revision, zero if not recorded
+	Tags     []string  // tags on the revision, of the dir or its nearest ancestors
+}
+
+// History returns the revisions where the dir changed up to the read
+// revision, which is the revision of WithRevision, in ascending order.
+//
+// The revisions since the compact revision are replayed from the watch
+// history of the commit times of the dir, its subdirs and its ancestors, the
+// revisions before are only known from the mod revisions of the current keys.
+func (c *Client) History(oos ...OpOption) ([]HistoryEntry, error) {
+	opt := parseOption(oos)
+	rev := c.rev
+	if opt.rev != 0 {
+		rev = opt.rev
+	}
+
+	key := timeKey(c.rdir)
+	ops := []Op{opGetPrefix(c.rdir, rev), opGetPrefix(c.mdir, rev), opGetPrefix(key, rev)}
+	for _, akey := range timeAncestors(c.rdir) {
+		ops = append(ops, opGet(akey, rev))
+	}
+	resp, err := c.backend.Txn(c.ctx, nil, ops, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rev == 0 {
+		rev = resp.Revision
+	}
+
+	times := make(map[int64]time.Time)
+	for _, r := range resp.Responses[:2] {
+		for _, kv := range r.Kvs {
+			times[kv.CreateRevision] = time.Time{}
+			times[kv.ModRevision] = time.Time{}
+		}
+	}
+
+	// every write on the dir or its subdirs
+	if kvs := resp.Responses[2].Kvs; len(kvs) != 0 {
+		err := c.replayTimes(key, prefixEnd(key), lastModRev(kvs), func(r int64, t time.Time) error {
+			times[r] = t
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the writes on the ancestors which change the dir
+	for _, r := range resp.Responses[3:] {
+		if len(r.Kvs) == 0 {
+			continue
+		}
+		err := c.replayTimes(r.Kvs[0].Key, "", r.Kvs[0].ModRevision, func(r int64, t time.Time) error {
+			if _, ok := times[r]; !ok {
+				changed, err := c.changedAt(r)
+				if err != nil || !changed {
+					return err
+				}
+			}
+			times[r] = t
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the tags of the ancestors are resolved for the dir too
+	tags, err := c.mdGetTagsTo(0)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64][]string)
+	for _, t := range tags {
+		names[t.Revision] = append(names[t.Revision], t.Name)
+	}
+
+	entries := make([]HistoryEntry, 0, len(times))
+	for r, t := range times {
+		if r <= rev {
+			entries = append(entries, HistoryEntry{Revision: r, Time: t, Tags: names[r]})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Revision < entries[j].Revision
+	})
+	return entries, nil
+}
+
+// replayTimes calls fn with the revision and the commit time of each write
+// of the time keys in [key, end), by watching them from the compact revision
+// to revision last. last must be the mod revision of a time key, so the watch
+// ends on it.
+func (c *Client) replayTimes(key, end string, last int64, fn func(int64, time.Time) error) error {
+	for from := int64(1); from <= last; {
+		ctx, cancel := context.WithCancel(c.ctx)
+		wch := c.backend.Watch(ctx, key, end, from)
+		done, compacted := false, false
+		var err error
+		for resp := range wch {
+			if resp.Err == ErrCompacted && resp.CompactRevision > from {
+				from, compacted = resp.CompactRevision, true
+				break
+			}
+			if err = resp.Err; err != nil {
+				break
+			}
+
+			for _, ev := range resp.Events {
+				rev := ev.Kv.ModRevision
+				if rev > last {
+					done = true
+					break
+				}
+				t, _ := time.Parse(time.RFC3339Nano, ev.Kv.Value)
+				if err = fn(rev, t); err != nil {
+					break
+				}
+				if rev == last {
+					done = true
+					break
+				}
+			}
+			if done || err != nil {
+				break
+			}
+		}
+		cancel()
+
+		switch {
+		case err != nil:
+			return err
+		case done:
+			return nil
+		case !compacted:
+			if err := c.ctx.Err(); err != nil {
+				return err
+			}
+			return ErrWatchClosed
+		}
+	}
+	return nil
+}
+
+// changedAt reports whether the dir has been changed on revision rev, a key
+// of it has been put or deleted. A compacted revision is unknown.
+func (c *Client) changedAt(rev int64) (bool, error) {
+	var ops []Op
+	for _, r := range []int64{rev, rev - 1} {
+		for _, prefix := range []string{c.rdir, c.mdir} {
+			op := opGetPrefix(prefix, r)
+			op.KeysOnly = true
+			ops = append(ops, op)
+		}
+	}
+	resp, err := c.backend.Txn(c.ctx, nil, ops, nil)
+	if err == ErrCompacted {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp.Responses[i].Count != resp.Responses[i+2].Count || lastModRev(resp.Responses[i].Kvs) == rev {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lastModRev returns the last mod revision of kvs.
+func lastModRev(kvs []*KeyValue) int64 {
+	var rev int64
+	for _, kv := range kvs {
+		if kv.ModRevision > rev {
+			rev = kv.ModRevision
+		}
+	}
+	return rev
+}
+
+// timeKey returns the key of the commit time of the last write on rdir.
+func timeKey(rdir string) string {
+	return dir.Join(Config.MD.TimeDir, rdir)
+}
+
+// timeAncestors returns the time keys of the ancestors of rdir.
+func timeAncestors(rdir string) []string {
+	depth := dir.Depth(rdir)
+	keys := make([]string, 0, depth)
+	for d := 1; d < depth; d++ {
+		keys = append(keys, timeKey(dir.ParentD(rdir, d)))
+	}
+	return keys
+}
@@ -0,0 +1,87 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("History", func() {
+	var (
+		ctx     = context.Background()
+		backend setcd.Backend
+		cli     *setcd.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		backend = setcd.NewMemoryBackend()
+		cli, err = setcd.NewWithBackend(backend, ctx, "/History")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cli.Put(map[string]interface{}{"host": "alpha", "tls": "on"})).To(Succeed())
+		Expect(cli.Put(map[string]interface{}{"host": "beta"}, setcd.WithTag("v1"))).To(Succeed())
+
+		other, err := cli.ShadowClone("/Other")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Put("gamma")).To(Succeed())
+
+		tc, err := cli.ShadowClone("tls")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tc.Delete()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("revisions of the dir", func() {
+		entries, err := cli.History()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(3))
+		for _, e := range entries {
+			Expect(e.Time.IsZero()).To(BeFalse())
+		}
+		Expect(entries[0].Tags).To(BeEmpty())
+		Expect(entries[1].Tags).To(Equal([]string{"v1"}))
+
+		Expect(cli.Get(setcd.WithRevision(entries[0].Revision))).To(Equal(map[string]interface{}{"host": "alpha", "tls": "on"}))
+		Expect(cli.Get(setcd.WithRevision(entries[1].Revision))).To(Equal(map[string]interface{}{"host": "beta", "tls": "on"}))
+		Expect(cli.Get(setcd.WithRevision(entries[2].Revision))).To(Equal(map[string]interface{}{"host": "beta"}))
+
+		entries, err = cli.History(setcd.WithRevision(entries[1].Revision))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+	})
+
+	Specify("writes on ancestors", func() {
+		Expect(cli.Put(map[string]interface{}{"port": "80"})).To(Succeed())
+
+		hc, err := cli.ShadowClone("host")
+		Expect(err).NotTo(HaveOccurred())
+		entries, err := hc.History()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		for _, e := range entries {
+			Expect(e.Time.IsZero()).To(BeFalse())
+		}
+		Expect(hc.Get(setcd.WithRevision(entries[0].Revision))).To(Equal("alpha"))
+		Expect(hc.Get(setcd.WithRevision(entries[1].Revision))).To(Equal("beta"))
+		Expect(entries[1].Tags).To(Equal([]string{"v1"}))
+		Expect(hc.Get(setcd.WithTag("v1"))).To(Equal("beta"))
+	})
+
+	Specify("compacted", func() {
+		entries, err := cli.History()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backend.Compact(ctx, entries[2].Revision)).To(Succeed())
+
+		compacted, err := cli.History()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(compacted[len(compacted)-1]).To(Equal(entries[2]))
+	})
+})
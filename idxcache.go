@@ -0,0 +1,130 @@
+package setcd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/helloyi/setcd/dir"
+)
+
+// idxCacheSize is the max number of slices in an idxCache.
+const idxCacheSize = 256
+
+// idxCache caches the indexes of slices in element order, so the element at a
+// position is resolved without a range read of the indexes before it.
+//
+// An entry is valid while the '__len__' of the slice keeps its mod revision,
+// every write which changes the order of a slice puts its '__len__'. The
+// entries of the slices written by a txn are updated from its write set, see
+// update.
+type idxCache struct {
+	mu      sync.Mutex
+	entries map[string]*idxEntry // by metadata dir of the slice
+}
+
+type idxEntry struct {
+	lenRev int64    // mod revision of '__len__'
+	idxes  []string // read-only
+}
+
+func newIdxCache() *idxCache {
+	return &idxCache{entries: make(map[string]*idxEntry)}
+}
+
+// get returns the cached indexes of the slice, or nil if they are stale.
+func (ic *idxCache) get(mdir string, lenRev int64) []string {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	e, ok := ic.entries[mdir]
+	if !ok || e.lenRev != lenRev {
+		return nil
+	}
+	return e.idxes
+}
+
+// put caches the indexes of the slice, evicts an entry when it is full.
+func (ic *idxCache) put(mdir string, lenRev int64, idxes []string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if _, ok := ic.entries[mdir]; !ok && len(ic.entries) >= idxCacheSize {
+		for k := range ic.entries {
+			delete(ic.entries, k)
+			break
+		}
+	}
+	ic.entries[mdir] = &idxEntry{lenRev: lenRev, idxes: idxes}
+}
+
+// update updates the cached indexes of the slices written by the committed
+// txn t, whose '__len__' has the mod revision rev now. The new indexes are
+// the indexes read by t, or the cached indexes of the revision read by t,
+// with the written indexes of t.
+func (ic *idxCache) update(t *stmTxn, rev int64) {
+	suffix := "/" + Config.MD.LenSubDir + "/"
+	for key := range t.wset {
+		if !strings.HasSuffix(key, suffix) {
+			continue
+		}
+		mdir := strings.TrimSuffix(key, Config.MD.LenSubDir+"/")
+		if txnKind(t, mdir) != Slice {
+			continue
+		}
+
+		idxes, ok := t.idxes[mdir]
+		if !ok {
+			lenKv, read := t.rset[key]
+			if !read {
+				continue
+			}
+			if lenKv != nil {
+				if idxes = ic.get(mdir, lenKv.ModRevision); idxes == nil {
+					continue
+				}
+			}
+		}
+		ic.put(mdir, rev, applyIdxes(t, mdir, idxes))
+	}
+}
+
+// applyIdxes returns the indexes of the slice on mdir with the writes of t.
+func applyIdxes(t *stmTxn, mdir string, idxes []string) []string {
+	idxesDir := dir.Join(mdir, Config.MD.IdxesSubDir)
+	set := make(map[string]bool, len(idxes))
+	for _, idx := range idxes {
+		set[idx] = true
+	}
+	for key, w := range t.wset {
+		if strings.HasPrefix(key, idxesDir) {
+			idx := strings.Trim(strings.TrimPrefix(key, idxesDir), "/")
+			set[idx] = w.val != nil
+		}
+	}
+
+	out := make([]string, 0, len(set))
+	for idx, ok := range set {
+		if ok {
+			out = append(out, idx)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// txnKind returns the kind of the dir on mdir after the txn t, or Invalid if
+// t has not read or written it.
+func txnKind(t *stmTxn, mdir string) Kind {
+	key := dir.Join(mdir, Config.MD.KindSubDir)
+	if w, ok := t.wset[key]; ok {
+		if w.val == nil {
+			return Invalid
+		}
+		return SKind(*w.val).ConvKind()
+	}
+	if kv := t.rset[key]; kv != nil {
+		return SKind(kv.Value).ConvKind()
+	}
+	return Invalid
+}
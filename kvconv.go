@@ -2,87 +2,101 @@ package setcd
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
-	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/helloyi/setcd/dir"
 )
 
+// kvMeta is the metadata of a dir read with its data, by key.
+type kvMeta map[string]string
+
+// readDir reads the data and metadata of the dir at rev in one txn, so a
+// value is parsed without any more reads.
+func (c *Client) readDir(rev int64) ([]*KeyValue, kvMeta, error) {
+	resps, _, err := c.stableRead(rev, func(rev int64) []Op {
+		return []Op{opGetPrefix(c.rdir, rev), opGetPrefix(c.mdir, rev)}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md := make(kvMeta, len(resps[1].Kvs))
+	for _, kv := range resps[1].Kvs {
+		md[kv.Key] = kv.Value
+	}
+	return resps[0].Kvs, md, nil
+}
+
 // kvParseKind ...
-func (c *Client) kvParseKind(kvs []*mvccpb.KeyValue) (Kind, error) {
+func (c *Client) kvParseKind(kvs []*KeyValue, md kvMeta) Kind {
 	if len(kvs) == 0 {
-		return Nil, nil
+		return Nil
 	}
 
-	key := string(kvs[0].Key)
-	if c.rdir == key {
-		return Scale, nil
+	// get kind from matedata: nil || map || slice
+	kind := SKind(md[dir.Join(c.mdir, Config.MD.KindSubDir)]).ConvKind()
+	if c.rdir == kvs[0].Key && kind == Invalid {
+		return Scale
 	}
-
-	// get kind from matedata: map || slice
-	return c.mdGetKind()
+	return kind
 }
 
-// kvParse ...
-func (c *Client) kvParse(kvs []*mvccpb.KeyValue) (interface{}, error) {
-	kind, err := c.kvParseKind(kvs)
-	if err != nil {
-		return nil, err
+// kvSubs returns the kvs of the subdirs, without the key of the dir.
+func (c *Client) kvSubs(kvs []*KeyValue) []*KeyValue {
+	if len(kvs) != 0 && kvs[0].Key == c.rdir {
+		return kvs[1:]
 	}
+	return kvs
+}
 
-	switch kind {
+// kvParse ...
+func (c *Client) kvParse(kvs []*KeyValue, md kvMeta) (interface{}, error) {
+	switch c.kvParseKind(kvs, md) {
 	case Nil:
 		return nil, nil
 	case Scale:
-		return c.kvParseScale(kvs)
+		return c.kvParseScale(kvs, md)
 	case Slice:
-		return c.kvParseSlice(kvs)
+		return c.kvParseSlice(kvs, md)
 	case Map:
-		return c.kvParseMap(kvs)
+		ret, err := c.kvParseMap(kvs, md)
+		if err != nil || !c.typedKeys {
+			return ret, err
+		}
+		return c.kvTypeKeys(ret, md)
 	case Invalid:
 		return nil, errors.New("Invalid type on " + c.odir)
-		// return c.kvParseInvlid(kvs)
+		// return c.kvParseInvlid(kvs, md)
 	default:
 		return nil, errors.New("undefined type on " + c.odir)
 	}
 }
 
-// kvParseScale ...
-func (c *Client) kvParseScale(kvs []*mvccpb.KeyValue) (interface{}, error) {
-	value := string(kvs[0].Value)
-
-	// priority parse float
-	fv, err := strconv.ParseFloat(value, 64)
-	if err == nil {
-		return fv, err
-	}
-
-	// then bool
-	bv, err := strconv.ParseBool(value)
-	if err == nil {
-		return bv, err
-	}
-
-	// must string
-	return value, nil
+// kvParseScale parses the scale by its type, or by the format of its value if
+// it has no type.
+func (c *Client) kvParseScale(kvs []*KeyValue, md kvMeta) (interface{}, error) {
+	return parseScale(kvs[0].Value, md[dir.Join(c.mdir, Config.MD.TypeSubDir)])
 }
 
 // kvParseMap ...
-func (c *Client) kvParseMap(kvs []*mvccpb.KeyValue) (map[string]interface{}, error) {
+func (c *Client) kvParseMap(kvs []*KeyValue, md kvMeta) (map[string]interface{}, error) {
 	ret := make(map[string]interface{})
+	kvs = c.kvSubs(kvs)
 
 	kvsLen := len(kvs)
 
 	for i := 0; i < kvsLen; {
 		kv := kvs[i]
-		key := string(kv.Key)
+		key := kv.Key
 		nb := c.nextBranch(key)
 		np := c.nextPath(key)
 
 		j := i + 1
 		for ; j < kvsLen; j++ {
-			jkey := string(kvs[j].Key)
+			jkey := kvs[j].Key
 			if !strings.HasPrefix(jkey, np) {
 				break
 			}
@@ -93,43 +107,77 @@ func (c *Client) kvParseMap(kvs []*mvccpb.KeyValue) (map[string]interface{}, err
 			return nil, err
 		}
 
-		iv, err := sc.kvParse(kvs[i:j])
+		iv, err := sc.kvParse(kvs[i:j], md)
 		if err != nil {
 			return nil, err
 		}
 
-		ret[nb] = iv
+		ret[dir.UnescapeKey(nb)] = iv
 		i = j
 	}
 
 	return ret, nil
 }
 
+// kvTypeKeys converts the keys of the map m by its key type, keys of a
+// TextMarshaler are kept as strings.
+func (c *Client) kvTypeKeys(m map[string]interface{}, md kvMeta) (interface{}, error) {
+	typ := md[dir.Join(c.mdir, Config.MD.KeyTypeSubDir)]
+	var kt reflect.Type
+	switch typ {
+	case scaleInt:
+		kt = reflect.TypeOf(int64(0))
+	case scaleUint:
+		kt = reflect.TypeOf(uint64(0))
+	case scaleBool:
+		kt = reflect.TypeOf(false)
+	default:
+		return m, nil
+	}
+
+	ret := reflect.MakeMapWithSize(reflect.MapOf(kt, ifaceType), len(m))
+	for key, val := range m {
+		kv, err := parseScale(key, typ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid map key '%s' on '%s'", key, c.odir)
+		}
+		vv := reflect.New(ifaceType).Elem()
+		if val != nil {
+			vv.Set(reflect.ValueOf(val))
+		}
+		ret.SetMapIndex(reflect.ValueOf(kv), vv)
+	}
+	return ret.Interface(), nil
+}
+
+var ifaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
 // kvParseSlice ...
-func (c *Client) kvParseSlice(kvs []*mvccpb.KeyValue) ([]interface{}, error) {
+func (c *Client) kvParseSlice(kvs []*KeyValue, md kvMeta) ([]interface{}, error) {
 	ret := make([]interface{}, 0)
+	kvs = c.kvSubs(kvs)
 
 	kvsLen := len(kvs)
 
 	for i := 0; i < kvsLen; {
 		kv := kvs[i]
-		key := string(kv.Key)
+		key := kv.Key
 		nb := c.nextBranch(key)
 		np := c.nextPath(key)
 
 		j := i + 1
 		for ; j < kvsLen; j++ {
-			jkey := string(kvs[j].Key)
+			jkey := kvs[j].Key
 			if !strings.HasPrefix(jkey, np) {
 				break
 			}
 		}
 
-		sc, err := c.shadowClone(strconv.Itoa(i), nb)
+		sc, err := c.shadowClone(strconv.Itoa(len(ret)), nb)
 		if err != nil {
 			return nil, err
 		}
-		iv, err := sc.kvParse(kvs[i:j])
+		iv, err := sc.kvParse(kvs[i:j], md)
 		if err != nil {
 			return nil, err
 		}
@@ -142,7 +190,7 @@ func (c *Client) kvParseSlice(kvs []*mvccpb.KeyValue) ([]interface{}, error) {
 }
 
 // kvParseInvlid ...
-func (c *Client) kvParseInvlid(kvs []*mvccpb.KeyValue) (interface{}, error) {
+func (c *Client) kvParseInvlid(kvs []*KeyValue, md kvMeta) (interface{}, error) {
 	kvsLen := len(kvs)
 
 	// next branch range map
@@ -150,7 +198,7 @@ func (c *Client) kvParseInvlid(kvs []*mvccpb.KeyValue) (interface{}, error) {
 	kind := Slice
 	for i := 0; i < kvsLen; {
 		kv := kvs[i]
-		key := string(kv.Key)
+		key := kv.Key
 		nb := c.nextBranch(key)
 		np := c.nextPath(key)
 
@@ -166,7 +214,7 @@ func (c *Client) kvParseInvlid(kvs []*mvccpb.KeyValue) (interface{}, error) {
 		}
 		j := i + 1
 		for ; j < kvsLen; j++ {
-			jkey := string(kvs[j].Key)
+			jkey := kvs[j].Key
 			if !strings.HasPrefix(jkey, np) {
 				break
 			}
@@ -189,7 +237,7 @@ func (c *Client) kvParseInvlid(kvs []*mvccpb.KeyValue) (interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		iv, err := sc.kvParse(kvs[rag.s:rag.e])
+		iv, err := sc.kvParse(kvs[rag.s:rag.e], md)
 		if err != nil {
 			return nil, err
 		}
@@ -1,45 +1,37 @@
 package setcd
 
 import (
+	"encoding"
 	"fmt"
 	"reflect"
+	"strconv"
 
-	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/helloyi/setcd/dir"
 )
 
 // (c *Client) GetMap ...
 func (c *Client) GetMap(oos ...OpOption) (map[string]interface{}, error) {
 	opt := parseOption(oos)
 
-	// check type
-	kind, err := c.mdGetKind()
+	rc, err := c.reader(opt)
 	if err != nil {
 		return nil, err
 	}
-	if kind != Map {
-		return nil, fmt.Errorf("invalid map type on '%s'", c.odir)
+	kvs, md, err := rc.readDir(rc.rev)
+	if err != nil {
+		return nil, err
 	}
 
-	etcdOpts := []clientv3.OpOption{
-		clientv3.WithPrefix(),
-		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
-	}
-	if opt.tag != "" {
-		rev, err := c.mdGetRev(opt.tag)
-		if err != nil {
-			return nil, err
-		}
-		etcdOpts = append(etcdOpts, clientv3.WithRev(rev))
+	// check type
+	if c.kvParseKind(kvs, md) != Map {
+		return nil, fmt.Errorf("invalid map type on '%s'", c.odir)
 	}
 
-	resp, err := c.Client.Get(c.ctx, c.rdir, etcdOpts...)
+	ret, err := c.kvParseMap(kvs, md)
 	if err != nil {
 		return nil, err
 	}
 
-	ret, err := c.kvParseMap(resp.Kvs)
-
 	if opt.eval {
 		return c.evalMap(ret, opt.evalTags, opt.evalVarFmt, opt.evalVarCheck)
 	}
@@ -58,13 +50,16 @@ func (c *Client) evalMap(val map[string]interface{}, etags map[string]string,
 	return ev.(map[string]interface{}), nil
 }
 
-func (c *Client) PutMap(in map[string]interface{}) error {
-	_, err := concurrency.NewSTM(c.Client, func(stm concurrency.STM) error {
-		s := newSTM(stm, c)
+// (c *Client) PutMap puts the map, with the options of an update such as
+// WithTag and WithReplace.
+func (c *Client) PutMap(in map[string]interface{}, oos ...OpOption) error {
+	opt := parseOption(oos)
+	return c.update(opt, func(s *STM) error {
+		if opt.replace {
+			s.clear()
+		}
 		return s.putMap(in)
-	}, concurrency.WithAbortContext(c.ctx))
-
-	return err
+	})
 }
 
 func (s *STM) putMap(in interface{}) error {
@@ -72,23 +67,39 @@ func (s *STM) putMap(in interface{}) error {
 	if v.Kind() != reflect.Map {
 		return fmt.Errorf("required map type of STM.putMap")
 	}
-	if s.mdGetKind() != Nil && s.mdGetKind() != Map {
-		return fmt.Errorf("invalid map type on '%s'", s.odir)
+	if kind := s.mdGetKind(); kind != Nil && kind != Map {
+		if !s.forceKind {
+			return fmt.Errorf("invalid map type on '%s'", s.odir)
+		}
+		s.clear()
 	}
 
-	oldLen, err := s.mdGetLen()
+	keyType, err := mapKeyType(v.Type().Key())
+	if err != nil {
+		return err
+	}
+	newLen, err := s.mdGetLen()
 	if err != nil {
 		return err
 	}
-	inLen := v.Len()
-	newLen := oldLen + int64(inLen)
+	if old := s.mdGetString(Config.MD.KeyTypeSubDir); old != keyType && newLen != 0 {
+		return fmt.Errorf("invalid map key type '%s' on '%s', but is '%s'", keyType, s.odir, old)
+	}
+	if keyType != "" {
+		s.mdPutString(Config.MD.KeyTypeSubDir, keyType)
+	}
+
 	for _, vkey := range v.MapKeys() {
 		// put key list
-		if vkey.Kind() != reflect.String {
-			return fmt.Errorf("required string type of map")
+		skey, err := formatMapKey(vkey, keyType)
+		if err != nil {
+			return err
+		}
+		key := dir.EscapeKey(skey)
+		if !s.mdIdxExists(key) {
+			s.mdPutIdx(key)
+			newLen++
 		}
-		key := vkey.String()
-		s.mdPutIdx(key)
 		ss, err := s.shadowClone(key, key)
 		if err != nil {
 			return err
@@ -98,26 +109,67 @@ func (s *STM) putMap(in interface{}) error {
 			return err
 		}
 	}
-	s.mdPutKind(Map)
+	s.putKind(Map)
 	s.mdPutLen(newLen)
 
 	return nil
 }
 
+// key types of a map in its metadata, besides the scale types
+const mapKeyText = "text" // text of an encoding.TextMarshaler
+
+// mapKeyType returns the key type of a map of key type t, which is "" for
+// string keys.
+func mapKeyType(t reflect.Type) (string, error) {
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return mapKeyText, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "", nil
+	case reflect.Bool:
+		return scaleBool, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return scaleInt, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return scaleUint, nil
+	default:
+		return "", fmt.Errorf("%s: map key '%s'", ErrUnsupportedType, t)
+	}
+}
+
+// formatMapKey formats the map key k of key type typ.
+func formatMapKey(k reflect.Value, typ string) (string, error) {
+	switch typ {
+	case mapKeyText:
+		if !k.Type().Implements(textMarshalerType) {
+			pk := reflect.New(k.Type())
+			pk.Elem().Set(k)
+			k = pk
+		}
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	case scaleBool:
+		return strconv.FormatBool(k.Bool()), nil
+	case scaleInt:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case scaleUint:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	default:
+		return k.String(), nil
+	}
+}
+
 // DoMap ...
 func (c *Client) DoMap(fn func(string, interface{}) bool, oos ...OpOption) error {
 	opt := parseOption(oos)
 
-	var etcdOpts []clientv3.OpOption
-	if opt.tag != "" {
-		rev, err := c.mdGetRev(opt.tag)
-		if err != nil {
-			return err
-		}
-		etcdOpts = []clientv3.OpOption{clientv3.WithRev(rev)}
+	rc, err := c.stableReader(opt)
+	if err != nil {
+		return err
 	}
 
-	kind, err := c.mdGetKind(etcdOpts...)
+	kind, err := rc.mdGetKind(rc.rev)
 	if err != nil {
 		return err
 	}
@@ -126,81 +178,25 @@ func (c *Client) DoMap(fn func(string, interface{}) bool, oos ...OpOption) error
 		return fmt.Errorf("not Map type on '%s'", c.odir)
 	}
 
-	idxes, err := c.mdGetIdxes(etcdOpts...)
+	idxes, err := rc.mdGetIdxes(rc.rev, Map)
 	if err != nil {
 		return err
 	}
 
 	for _, key := range idxes {
-		cs, err := c.shadowClone(key, key)
+		cs, err := rc.shadowClone(key, key)
 		if err != nil {
 			return err
 		}
 
-		val, err := cs.Get(oos...)
+		val, err := cs.get(opt)
 		if err != nil {
 			return err
 		}
 
-		if ok := fn(key, val); !ok {
+		if ok := fn(dir.UnescapeKey(key), val); !ok {
 			return nil
 		}
 	}
 	return nil
 }
-
-// putMap ...
-func (c *Client) putMap(in interface{}) (*clientv3.PutResponse, error) {
-	v := reflect.ValueOf(in)
-	if v.Kind() != reflect.Map {
-		return nil, fmt.Errorf("required map type of Client.putMap, but is '%s'", v.Kind())
-	}
-
-	kind, err := c.mdGetKind()
-	if err != nil {
-		return nil, err
-	}
-	if kind != Nil && kind != Map {
-		return nil, fmt.Errorf("invalid map type on '%s'", c.odir)
-	}
-
-	oldLen, err := c.mdGetLen()
-	if err != nil {
-		return nil, err
-	}
-	newLen := oldLen
-	for _, vkey := range v.MapKeys() {
-		// put key list
-		if vkey.Kind() != reflect.String {
-			return nil, fmt.Errorf("required string type of map")
-		}
-		key := vkey.String()
-		isExists, err := c.mdIdxExists(key)
-		if err != nil {
-			return nil, err
-		}
-		if !isExists {
-			_, err := c.mdPutIdx(key)
-			if err != nil {
-				return nil, err
-			}
-			newLen++
-		}
-		cs, err := c.shadowClone(key, key)
-		if err != nil {
-			return nil, err
-		}
-		vval := v.MapIndex(vkey)
-		if _, err := cs.put(vval.Interface()); err != nil {
-			return nil, err
-		}
-	}
-
-	if kind == Nil {
-		_, err = c.mdPutKind(Map)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return c.mdPutLen(newLen)
-}
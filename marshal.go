@@ -0,0 +1,116 @@
+package setcd
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Marshaler is implemented by types which put themselves as another value,
+// such as a scale.
+type Marshaler interface {
+	MarshalSetcd() (interface{}, error)
+}
+
+// Unmarshaler is implemented by types which decode themselves from the value
+// of a dir, as it is returned by Get.
+type Unmarshaler interface {
+	UnmarshalSetcd(value interface{}) error
+}
+
+// marshal returns the value which in is put as, if in is a Marshaler or
+// an encoding.TextMarshaler, a time.Duration or an url.URL.
+func marshal(in interface{}) (interface{}, bool, error) {
+	v := reflect.ValueOf(in)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil, false, nil
+	}
+	if pt := reflect.PtrTo(v.Type()); v.Kind() != reflect.Ptr &&
+		(pt.Implements(marshalerType) || pt.Implements(textMarshalerType)) {
+		// methods of pointer receivers
+		pv := reflect.New(v.Type())
+		pv.Elem().Set(v)
+		in = pv.Interface()
+	}
+
+	switch x := in.(type) {
+	case Marshaler:
+		out, err := x.MarshalSetcd()
+		return out, true, err
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		return string(text), true, err
+	case time.Duration:
+		return x.String(), true, nil
+	case url.URL:
+		return x.String(), true, nil
+	}
+	return nil, false, nil
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// unmarshal decodes in into v, if v is an Unmarshaler or an
+// encoding.TextUnmarshaler, a time.Duration or an url.URL.
+func unmarshal(in interface{}, v reflect.Value) (bool, error) {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface || !v.CanAddr() ||
+		v.Type() == bigIntType || v.Type() == bigFloatType {
+		return false, nil
+	}
+
+	switch x := v.Addr().Interface().(type) {
+	case Unmarshaler:
+		return true, x.UnmarshalSetcd(in)
+	case encoding.TextUnmarshaler:
+		text, ok := scaleText(in)
+		if !ok {
+			return true, fmt.Errorf("%s: decode '%s' into '%s'", ErrUnsupportedType, kindOf(in), v.Type())
+		}
+		return true, x.UnmarshalText([]byte(text))
+	case *time.Duration:
+		text, ok := in.(string)
+		if !ok { // nanoseconds
+			return false, nil
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return true, err
+		}
+		*x = d
+		return true, nil
+	case *url.URL:
+		text, ok := in.(string)
+		if !ok {
+			return true, fmt.Errorf("%s: decode '%s' into '%s'", ErrUnsupportedType, kindOf(in), v.Type())
+		}
+		u, err := url.Parse(text)
+		if err != nil {
+			return true, err
+		}
+		*x = *u
+		return true, nil
+	}
+	return false, nil
+}
+
+// scaleText returns the text of a scale, an untyped scale may be parsed as a
+// number or bool.
+func scaleText(in interface{}) (string, bool) {
+	switch x := in.(type) {
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	case map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(x), true
+	}
+}
@@ -0,0 +1,500 @@
+package setcd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// memBackend is a pure in-memory MVCC Backend.
+//
+// It keeps the history of every key since the compact revision, so it can
+// serve reads and watches at any revision after it. It is useful for running
+// setcd without an etcd process, for example in unit tests.
+type memBackend struct {
+	mu       sync.Mutex
+	rev      int64                // current revision
+	compact  int64                // compact revision, the history before it is discarded
+	keys     []string             // sorted keys with history, including deleted ones
+	history  map[string][]*memRev // revisions of each key, ascending
+	watchers map[*memWatcher]struct{}
+	closed   bool
+}
+
+// memRev is a revision of a key; a nil kv is a deletion.
+type memRev struct {
+	rev int64
+	kv  *KeyValue
+}
+
+// NewMemoryBackend creates an empty in-memory Backend.
+func NewMemoryBackend() Backend {
+	return &memBackend{
+		rev:      1, // same as a fresh etcd
+		history:  make(map[string][]*memRev),
+		watchers: make(map[*memWatcher]struct{}),
+	}
+}
+
+// Do ...
+func (b *memBackend) Do(ctx context.Context, op Op) (*Response, error) {
+	resp, err := b.Txn(ctx, nil, []Op{op}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Responses[0], nil
+}
+
+// Txn ...
+func (b *memBackend) Txn(ctx context.Context, cmps []Cmp, thenOps, elseOps []Op) (*TxnResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosedBackend
+	}
+
+	succeeded := true
+	for _, cmp := range cmps {
+		if !b.compare(cmp) {
+			succeeded = false
+			break
+		}
+	}
+	ops := thenOps
+	if !succeeded {
+		ops = elseOps
+	}
+
+	// validate the ops before any change, a txn is all or nothing
+	for _, op := range ops {
+		if op.Type == OpGet {
+			if err := b.checkRev(op.Rev); err != nil {
+				return nil, err
+			}
+		} else if op.Type != OpPut && op.Type != OpDelete {
+			return nil, fmt.Errorf("%s: op type '%d'", ErrInvalidArgument, op.Type)
+		}
+	}
+
+	// writes of the txn share the next revision
+	wrev := b.rev + 1
+	var events []*Event
+
+	resp := &TxnResponse{
+		Succeeded: succeeded,
+		Responses: make([]*Response, len(ops)),
+	}
+	for i, op := range ops {
+		switch op.Type {
+		case OpGet:
+			resp.Responses[i] = b.get(op)
+		case OpPut:
+			events = append(events, b.put(op.Key, op.Value, wrev))
+			resp.Responses[i] = &Response{}
+		case OpDelete:
+			evs := b.delete(op.Key, op.End, wrev)
+			events = append(events, evs...)
+			resp.Responses[i] = &Response{Deleted: int64(len(evs))}
+		}
+	}
+
+	if len(events) > 0 {
+		b.rev = wrev
+		b.notify(events)
+	}
+
+	resp.Revision = b.rev
+	for _, r := range resp.Responses {
+		r.Revision = b.rev
+	}
+	return resp, nil
+}
+
+// Watch ...
+func (b *memBackend) Watch(ctx context.Context, key, end string, rev int64) <-chan WatchResponse {
+	w := &memWatcher{
+		key:    key,
+		end:    end,
+		ch:     make(chan WatchResponse),
+		signal: make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		w.enqueue(WatchResponse{Revision: b.rev, Err: ErrClosedBackend})
+	} else if rev > 0 && rev < b.compact {
+		w.enqueue(WatchResponse{Revision: b.rev, CompactRevision: b.compact, Err: ErrCompacted})
+	} else {
+		if rev > 0 && rev <= b.rev {
+			for _, resp := range b.replay(key, end, rev) {
+				w.enqueue(resp)
+			}
+		}
+		b.watchers[w] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.watchers, w)
+			b.mu.Unlock()
+			close(w.ch)
+		}()
+		w.run(ctx)
+	}()
+
+	return w.ch
+}
+
+// Rev ...
+func (b *memBackend) Rev(ctx context.Context) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosedBackend
+	}
+	return b.rev, nil
+}
+
+// Compact ...
+func (b *memBackend) Compact(ctx context.Context, rev int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosedBackend
+	}
+	if rev > b.rev {
+		return ErrFutureRev
+	}
+	if rev <= b.compact {
+		return ErrCompacted
+	}
+	b.compact = rev
+
+	keys := b.keys[:0]
+	for _, key := range b.keys {
+		revs := b.history[key]
+		// keep the revision of the key at rev, unless it is a deletion
+		i := sort.Search(len(revs), func(i int) bool { return revs[i].rev > rev })
+		if i > 0 && revs[i-1].kv != nil {
+			i--
+		}
+		if i == len(revs) {
+			delete(b.history, key)
+			continue
+		}
+		b.history[key] = revs[i:]
+		keys = append(keys, key)
+	}
+	b.keys = keys
+	return nil
+}
+
+// Close ...
+func (b *memBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for w := range b.watchers {
+		w.enqueue(WatchResponse{Revision: b.rev, Err: ErrClosedBackend})
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// -----------------------------internal functions--------------------------------------
+// -------------------------------------------------------------------------------------
+
+// checkRev checks a read revision.
+func (b *memBackend) checkRev(rev int64) error {
+	if rev > b.rev {
+		return ErrFutureRev
+	}
+	if rev > 0 && rev < b.compact {
+		return ErrCompacted
+	}
+	return nil
+}
+
+// at returns the key-value pair of key at revision rev, 0 means the current one.
+func (b *memBackend) at(key string, rev int64) *KeyValue {
+	revs := b.history[key]
+	if rev <= 0 {
+		if len(revs) == 0 {
+			return nil
+		}
+		return revs[len(revs)-1].kv
+	}
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].rev > rev })
+	if i == 0 {
+		return nil
+	}
+	return revs[i-1].kv
+}
+
+// rangeKeys returns the keys in [key, end) ever written.
+func (b *memBackend) rangeKeys(key, end string) []string {
+	if end == "" {
+		if _, ok := b.history[key]; ok {
+			return []string{key}
+		}
+		return nil
+	}
+
+	start := sort.SearchStrings(b.keys, key)
+	stop := start
+	for stop < len(b.keys) && inRange(b.keys[stop], key, end) {
+		stop++
+	}
+	return b.keys[start:stop]
+}
+
+// rangeAt returns the key-value pairs in [key, end) at revision rev.
+func (b *memBackend) rangeAt(key, end string, rev int64) []*KeyValue {
+	var kvs []*KeyValue
+	for _, k := range b.rangeKeys(key, end) {
+		if kv := b.at(k, rev); kv != nil {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs
+}
+
+func (b *memBackend) get(op Op) *Response {
+	kvs := b.rangeAt(op.Key, op.End, op.Rev)
+	resp := &Response{Count: int64(len(kvs))}
+	if op.CountOnly {
+		return resp
+	}
+	if op.Limit > 0 && int64(len(kvs)) > op.Limit {
+		kvs = kvs[:op.Limit]
+		resp.More = true
+	}
+
+	resp.Kvs = make([]*KeyValue, len(kvs))
+	for i, kv := range kvs {
+		ckv := *kv
+		if op.KeysOnly {
+			ckv.Value = ""
+		}
+		resp.Kvs[i] = &ckv
+	}
+	return resp
+}
+
+func (b *memBackend) put(key, val string, rev int64) *Event {
+	kv := &KeyValue{
+		Key:            key,
+		Value:          val,
+		CreateRevision: rev,
+		ModRevision:    rev,
+		Version:        1,
+	}
+	// a key written twice in one txn is modified once
+	if prev := b.at(key, rev-1); prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		kv.Version = prev.Version + 1
+	}
+	b.record(key, rev, kv)
+	return &Event{Type: EventPut, Kv: kv}
+}
+
+func (b *memBackend) delete(key, end string, rev int64) []*Event {
+	var events []*Event
+	for _, kv := range b.rangeAt(key, end, 0) {
+		b.record(kv.Key, rev, nil)
+		events = append(events, &Event{
+			Type: EventDelete,
+			Kv:   &KeyValue{Key: kv.Key, ModRevision: rev},
+		})
+	}
+	return events
+}
+
+// record appends a revision of key; a later write in the same txn wins.
+func (b *memBackend) record(key string, rev int64, kv *KeyValue) {
+	revs, ok := b.history[key]
+	if !ok {
+		i := sort.SearchStrings(b.keys, key)
+		b.keys = append(b.keys, "")
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = key
+	}
+
+	if n := len(revs); n > 0 && revs[n-1].rev == rev {
+		revs[n-1].kv = kv
+		return
+	}
+	b.history[key] = append(revs, &memRev{rev: rev, kv: kv})
+}
+
+func (b *memBackend) compare(cmp Cmp) bool {
+	kvs := b.rangeAt(cmp.Key, cmp.End, 0)
+	if len(kvs) == 0 {
+		if cmp.Target == CmpValue {
+			return false
+		}
+		return compareKV(cmp, &KeyValue{})
+	}
+	for _, kv := range kvs {
+		if !compareKV(cmp, kv) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareKV(cmp Cmp, kv *KeyValue) bool {
+	var r int
+	switch cmp.Target {
+	case CmpValue:
+		r = compareString(kv.Value, cmp.Value)
+	case CmpVersion:
+		r = compareInt(kv.Version, cmp.Rev)
+	case CmpCreateRevision:
+		r = compareInt(kv.CreateRevision, cmp.Rev)
+	case CmpModRevision:
+		r = compareInt(kv.ModRevision, cmp.Rev)
+	default:
+		return false
+	}
+
+	switch cmp.Result {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case "<":
+		return r < 0
+	case ">":
+		return r > 0
+	default:
+		return false
+	}
+}
+
+func compareString(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// replay returns the history events in [key, end) from revision rev, grouped by revision.
+func (b *memBackend) replay(key, end string, rev int64) []WatchResponse {
+	byRev := make(map[int64][]*Event)
+	for _, k := range b.rangeKeys(key, end) {
+		for _, r := range b.history[k] {
+			if r.rev < rev {
+				continue
+			}
+			ev := &Event{Type: EventPut, Kv: r.kv}
+			if r.kv == nil {
+				ev = &Event{Type: EventDelete, Kv: &KeyValue{Key: k, ModRevision: r.rev}}
+			}
+			byRev[r.rev] = append(byRev[r.rev], ev)
+		}
+	}
+
+	revs := make([]int64, 0, len(byRev))
+	for r := range byRev {
+		revs = append(revs, r)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i] < revs[j] })
+
+	resps := make([]WatchResponse, len(revs))
+	for i, r := range revs {
+		resps[i] = WatchResponse{Revision: r, Events: byRev[r]}
+	}
+	return resps
+}
+
+// notify sends the events of a committed txn to the watchers.
+func (b *memBackend) notify(events []*Event) {
+	for w := range b.watchers {
+		var evs []*Event
+		for _, ev := range events {
+			if inRange(ev.Kv.Key, w.key, w.end) {
+				evs = append(evs, ev)
+			}
+		}
+		if len(evs) > 0 {
+			w.enqueue(WatchResponse{Revision: b.rev, Events: evs})
+		}
+	}
+}
+
+// memWatcher is a watcher of memBackend.
+//
+// Responses are queued without limit, so a slow receiver never blocks writers.
+type memWatcher struct {
+	key, end string
+	ch       chan WatchResponse
+
+	mu     sync.Mutex
+	queue  []WatchResponse
+	signal chan struct{}
+}
+
+func (w *memWatcher) enqueue(resp WatchResponse) {
+	w.mu.Lock()
+	w.queue = append(w.queue, resp)
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memWatcher) run(ctx context.Context) {
+	for {
+		w.mu.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+
+		for _, resp := range queue {
+			select {
+			case w.ch <- resp:
+			case <-ctx.Done():
+				return
+			}
+			if resp.Err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-w.signal:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
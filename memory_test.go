@@ -0,0 +1,100 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("MemoryBackend", func() {
+	var (
+		ctx     context.Context
+		backend setcd.Backend
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		backend = setcd.NewMemoryBackend()
+	})
+
+	AfterEach(func() {
+		err := backend.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("Get at revision", func() {
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpPut, Key: "/a", Value: "1"})
+		Expect(err).NotTo(HaveOccurred())
+		rev := resp.Revision
+
+		_, err = backend.Do(ctx, setcd.Op{Type: setcd.OpPut, Key: "/a", Value: "2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err = backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: "/a", Rev: rev})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Kvs).To(HaveLen(1))
+		Expect(resp.Kvs[0].Value).To(Equal("1"))
+
+		resp, err = backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: "/a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Kvs[0].Value).To(Equal("2"))
+		Expect(resp.Kvs[0].Version).To(BeEquivalentTo(2))
+	})
+
+	Specify("Txn", func() {
+		cmps := []setcd.Cmp{{Key: "/a", Target: setcd.CmpValue, Result: "!=", Value: ""}}
+		resp, err := backend.Txn(ctx, cmps, nil, []setcd.Op{
+			{Type: setcd.OpPut, Key: "/a/1", Value: "x"},
+			{Type: setcd.OpPut, Key: "/a/2", Value: "y"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Succeeded).To(BeFalse())
+
+		get, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: "/a/", End: "/a0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get.Count).To(BeEquivalentTo(2))
+		Expect(get.Kvs[0].ModRevision).To(Equal(get.Kvs[1].ModRevision))
+	})
+
+	Specify("Watch from revision", func() {
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpPut, Key: "/w/1", Value: "x"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = backend.Do(ctx, setcd.Op{Type: setcd.OpDelete, Key: "/w/1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		wctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		wch := backend.Watch(wctx, "/w/", "/w0", resp.Revision)
+
+		wresp := <-wch
+		Expect(wresp.Events).To(HaveLen(1))
+		Expect(wresp.Events[0].Type).To(Equal(setcd.EventPut))
+		wresp = <-wch
+		Expect(wresp.Events[0].Type).To(Equal(setcd.EventDelete))
+
+		_, err = backend.Do(ctx, setcd.Op{Type: setcd.OpPut, Key: "/w/2", Value: "y"})
+		Expect(err).NotTo(HaveOccurred())
+		wresp = <-wch
+		Expect(wresp.Events[0].Kv.Key).To(Equal("/w/2"))
+	})
+
+	Specify("Client", func() {
+		cli, err := setcd.NewWithBackend(backend, ctx, "/Setcd")
+		Expect(err).NotTo(HaveOccurred())
+
+		data := map[string]interface{}{
+			"k2": true,
+			"k3": "string",
+			"k4": []interface{}{"a", "b", "c"},
+		}
+		err = cli.Put(data, setcd.WithLock())
+		Expect(err).NotTo(HaveOccurred())
+
+		res, err := cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(data))
+	})
+})
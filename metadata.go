@@ -2,10 +2,11 @@ package setcd
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/coreos/etcd/clientv3"
 	"github.com/helloyi/setcd/dir"
 )
 
@@ -50,7 +51,6 @@ func (s *STM) mdGetInt(fieldDir string) (int64, error) {
 	if sv == "" {
 		return 0, nil
 	}
-	fmt.Println("STM.mdGetInt", sv)
 	return strconv.ParseInt(sv, 10, 64)
 }
 
@@ -80,140 +80,271 @@ func (s *STM) mdPutIdx(idx string) error {
 	return s.mdPutString(idxSubDir, idx)
 }
 
-//
-// Client functions
-//
-
-func (c *Client) mdGetLen() (int64, error) {
-	return c.mdGetInt(Config.MD.LenSubDir)
+// mdDelIdx ...
+func (s *STM) mdDelIdx(idx string) {
+	s.stm.Del(dir.Join(s.mdir, Config.MD.IdxesSubDir, idx))
 }
 
-func (c *Client) mdGetKind(opts ...clientv3.OpOption) (Kind, error) {
-	defaultOpts := []clientv3.OpOption{
-		clientv3.WithPrefix(),
-		clientv3.WithKeysOnly(),
-		clientv3.WithLimit(1),
+// mdIdxExists ...
+func (s *STM) mdIdxExists(idx string) bool {
+	idxSubDir := dir.Join(Config.MD.IdxesSubDir, idx)
+	return s.mdGetString(idxSubDir) != ""
+}
+
+// fence guards the txn against the staged writes on the dir, its subdirs and
+// its ancestors, and takes the dir as the scope of a staged commit. The txn
+// waits for a pending marker, a stale one is taken over and its staged write
+// is rolled back.
+func (s *STM) fence() error {
+	key := pendingKey(s.rdir)
+	end := prefixEnd(key)
+	markers := s.stm.Range(key, end)
+	for _, akey := range pendingAncestors(s.rdir) {
+		if kv := s.stm.read(akey); kv != nil {
+			markers = append(markers, kv)
+		}
 	}
-	opts = append(defaultOpts, opts...)
+	for _, kv := range markers {
+		ok, err := takeOverStaged(s.stm.ctx, s.stm.backend, kv)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return stmWait{fmt.Errorf("%s: '%s'", ErrPendingWrite, s.odir)}
+		}
+	}
+	if len(markers) != 0 {
+		return errRetrySTM
+	}
+
+	s.stm.conds = append(s.stm.conds, Cmp{Key: key, End: end, Target: CmpVersion, Result: "="})
+	s.stm.scopes = append(s.stm.scopes, s.rdir, s.mdir)
+	s.stm.marker = key
+	return nil
+}
 
-	resp, err := c.Client.Get(c.ctx, c.rdir, opts...)
+//
+// Client functions
+//
+
+func (c *Client) mdGetKind(rev int64) (Kind, error) {
+	kindKey := dir.Join(c.mdir, Config.MD.KindSubDir)
+	resps, _, err := c.stableRead(rev, func(rev int64) []Op {
+		op := opGetPrefix(c.rdir, rev)
+		op.KeysOnly = true
+		op.Limit = 1
+		return []Op{op, opGet(kindKey, rev)}
+	})
 	if err != nil {
 		return Invalid, err
 	}
 
-	if resp.Count == 0 {
+	kvs := resps[0].Kvs
+	if len(kvs) == 0 {
 		return Nil, nil
 	}
 
-	key := string(resp.Kvs[0].Key)
-	if c.rdir == key {
-		return Scale, nil
+	kind := Invalid
+	if mdKvs := resps[1].Kvs; len(mdKvs) != 0 {
+		kind = SKind(mdKvs[0].Value).ConvKind()
 	}
-
-	sv, err := c.mdGetString(Config.MD.KindSubDir)
-	if err != nil {
-		return Invalid, err
+	if c.rdir == kvs[0].Key && kind == Invalid {
+		return Scale, nil
 	}
-	return SKind(sv).ConvKind(), nil
-}
-
-// mdGetLastID ...
-func (c *Client) mdGetLastID() (int64, error) {
-	return c.mdGetInt(Config.MD.LastIDSubDir)
+	return kind, nil
 }
 
 //
 // Tag
 //
 
-func (c *Client) mdGetRev(tag string) (int64, error) {
-	tagPath := c.mdGetTagPath(tag)
+// mdGetTag returns the tag of the dir, or of its nearest tagged ancestor.
+func (c *Client) mdGetTag(tag string) (*Tag, error) {
+	depth := dir.Depth(c.rdir)
+	ops := make([]Op, 0, depth+2)
+	for d := depth; d >= 0; d-- {
+		pdir := dir.ParentD(c.rdir, d)
+		ops = append(ops, opGet(tagPath(pdir, tag), 0))
+		if d == 1 {
+			ops = append(ops, opGet(legacyTagPath(pdir, tag), 0))
+		}
+	}
 
-	resp, err := c.Client.Get(c.ctx, tagPath)
+	resp, err := c.backend.Txn(c.ctx, nil, ops, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-
-	if resp.Count == 0 {
-		return 0, fmt.Errorf("the tag '%s' not exists", tag)
+	for _, r := range resp.Responses {
+		if len(r.Kvs) != 0 {
+			return parseTag(tag, r.Kvs[0].Value)
+		}
 	}
-
-	val := string(resp.Kvs[0].Value)
-	rev, err := strconv.ParseInt(val, 10, 64)
+	return nil, fmt.Errorf("%s: '%s'", ErrTagNotFound, tag)
+}
+
+// mdPutTag tags revision rev with the tag options of opt.
+func (c *Client) mdPutTag(opt *Option, rev int64) error {
+	var err error
+	t := &Tag{
+		Name:     opt.tag,
+		Revision: rev,
+		Message:  opt.tagMessage,
+		Author:   opt.tagAuthor,
+		Created:  time.Now().UTC(),
+	}
+	if opt.tagArchive {
+		if t.Archive, err = c.archive(opt.tag, rev); err != nil {
+			return err
+		}
+	}
+	val, err := t.marshal()
 	if err != nil {
-		fmt.Println("Client.mdgetrev ", val)
-		return 0, err
+		return err
 	}
 
-	return rev, nil
+	var old *Tag
+	_, err = runSTM(c.ctx, c.backend, func(stm *stmTxn) error {
+		key, oval := c.stmGetTag(stm, opt.tag)
+		old = nil
+		if oval != "" {
+			if !opt.tagForce {
+				return fmt.Errorf("%s: '%s'", ErrTagExists, opt.tag)
+			}
+			var err error
+			if old, err = parseTag(opt.tag, oval); err != nil {
+				return err
+			}
+		}
+		stm.Del(key)
+		stm.Put(c.mdGetTagPath(opt.tag), val)
+		return nil
+	})
+	if err != nil {
+		if t.Archive != "" {
+			c.dropArchive(t.Archive)
+		}
+		return err
+	}
+	if old != nil && old.Archive != "" {
+		return c.dropArchive(old.Archive)
+	}
+	return nil
 }
 
-func (c *Client) mdPutTag(tag string, rev int64) error {
-	tagPath := c.mdGetTagPath(tag)
-	kvc := clientv3.NewKV(c.Client)
-
-	resp, err := kvc.Txn(c.ctx).
-		If(clientv3.Compare(clientv3.Value(tagPath), "!=", "")).
-		Else(clientv3.OpPut(tagPath, strconv.FormatInt(rev, 10))).
-		Commit()
-
-	if resp.Succeeded {
-		return fmt.Errorf("the tag '%s' already exists", tag)
+// stmGetTag returns the key and value of the tag of the dir, the value is ""
+// if the tag does not exist.
+func (c *Client) stmGetTag(stm *stmTxn, tag string) (string, string) {
+	key := c.mdGetTagPath(tag)
+	if val := stm.Get(key); val != "" {
+		return key, val
+	}
+	if dir.Depth(c.rdir) == 1 {
+		legacy := legacyTagPath(c.rdir, tag)
+		if val := stm.Get(legacy); val != "" {
+			return legacy, val
+		}
 	}
-	return err
+	return key, ""
 }
 
-// mdGetTagPath ...
+// mdGetTagPath returns the key of the tag in the namespace of the dir.
 func (c *Client) mdGetTagPath(tag string) string {
-	// TODO:
-	// bug: when the path is eq $root/$tagSubDir/$tag
-	return dir.Join(c.mdGetTagRoot(), tag)
-}
-
-func (c *Client) mdGetTagRoot() string {
-	tagRootBranches := strings.Split(c.mdir, "/")[:3]
-	tagRoot := dir.Join(tagRootBranches...)
-	tagRoot = dir.Join("/", tagRoot, Config.MD.TagsSubDir)
-	return tagRoot
-}
-
-func (c *Client) mdGetTags() ([]string, error) {
-	tagRoot := c.mdGetTagRoot()
-	resp, err := c.Client.Get(c.ctx, tagRoot,
-		clientv3.WithPrefix(),
-		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortAscend))
+	return tagPath(c.rdir, tag)
+}
+
+// tagPath returns the key of the tag of rdir, the tags of rdir are the keys
+// under the tag dir of rdir without a "/".
+func tagPath(rdir, tag string) string {
+	return dir.Join(Config.MD.TagsDir, rdir) + tag
+}
+
+// legacyTagPath returns the key of the tag of the first-level dir rdir in the
+// old layout, where the tags are in the metadata of the dir.
+func legacyTagPath(rdir, tag string) string {
+	return dir.Join(legacyTagDir(rdir), tag)
+}
+
+// legacyTagDir ...
+func legacyTagDir(rdir string) string {
+	return dir.Join(Config.MD.RootDir, rdir, Config.MD.TagsSubDir)
+}
+
+// mdGetTags returns the tags of the dir sorted by revision.
+func (c *Client) mdGetTags() ([]*Tag, error) {
+	return c.mdGetTagsTo(dir.Depth(c.rdir))
+}
+
+// mdGetTagsTo returns the tags of the dir and its ancestors down to depth top
+// sorted by revision, a tag of a nearer dir hides the same name of the
+// ancestors, as in mdGetTag.
+func (c *Client) mdGetTagsTo(top int) ([]*Tag, error) {
+	var (
+		ops    []Op
+		roots  []string
+		legacy []bool
+	)
+	for d := dir.Depth(c.rdir); d >= top; d-- {
+		pdir := dir.ParentD(c.rdir, d)
+		ops = append(ops, opGetPrefix(tagPath(pdir, ""), 0))
+		roots = append(roots, tagPath(pdir, ""))
+		legacy = append(legacy, false)
+		if d == 1 {
+			ops = append(ops, opGetPrefix(legacyTagDir(pdir), 0))
+			roots = append(roots, legacyTagDir(pdir))
+			legacy = append(legacy, true)
+		}
+	}
+	resp, err := c.backend.Txn(c.ctx, nil, ops, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	tags := make([]string, resp.Count)
-	for i, kv := range resp.Kvs {
-		key := string(kv.Key)
-		tag := strings.TrimPrefix(key, tagRoot)
-		tag = strings.Trim(tag, "/")
-		tags[i] = tag
+	var tags []*Tag
+	seen := make(map[string]bool)
+	for i, r := range resp.Responses {
+		for _, kv := range r.Kvs {
+			name := strings.TrimPrefix(kv.Key, roots[i])
+			if legacy[i] {
+				name = strings.Trim(name, "/")
+			}
+			// tags of the subdirs
+			if strings.Contains(name, "/") || seen[name] {
+				continue
+			}
+			t, err := parseTag(name, kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			seen[name] = true
+			tags = append(tags, t)
+		}
 	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Revision < tags[j].Revision
+	})
 
 	return tags, nil
 }
 
-func (c *Client) mdGetIdxes(opts ...clientv3.OpOption) ([]string, error) {
+// mdGetIdxes returns the indexes of a map in insertion order, or the indexes
+// of a slice in element order.
+func (c *Client) mdGetIdxes(rev int64, kind Kind) ([]string, error) {
 	idxDir := dir.Join(c.mdir, Config.MD.IdxesSubDir)
 
-	etcdOpts := []clientv3.OpOption{
-		clientv3.WithPrefix(),
-		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortAscend),
-	}
-	etcdOpts = append(etcdOpts, opts...)
-	resp, err := c.Client.Get(c.ctx, idxDir,
-		etcdOpts...)
+	resps, _, err := c.stableRead(rev, func(rev int64) []Op {
+		return []Op{opGetPrefix(idxDir, rev)}
+	})
 	if err != nil {
 		return nil, err
 	}
+	resp := resps[0]
+	if kind == Map {
+		sortByModRev(resp.Kvs)
+	}
 
 	idxes := make([]string, resp.Count)
 	for i, kv := range resp.Kvs {
-		key := string(kv.Key)
+		key := kv.Key
 		idx := strings.TrimPrefix(key, idxDir)
 		idx = strings.Trim(idx, "/")
 		idxes[i] = idx
@@ -222,107 +353,131 @@ func (c *Client) mdGetIdxes(opts ...clientv3.OpOption) ([]string, error) {
 	return idxes, nil
 }
 
-func (c *Client) mdGetIdxWithOrder(num int64) (string, error) {
-	idxesDir := dir.Join(c.mdir, Config.MD.IdxesSubDir)
-	startKey := dir.Join(idxesDir, "\x00")
-	endKey := dir.Join(idxesDir, "\xFF")
-	resp, err := c.Client.Get(c.ctx, startKey,
-		clientv3.WithFromKey(),
-		clientv3.WithRange(endKey),
-		clientv3.WithLimit(num+1),
-		clientv3.WithKeysOnly())
+// mdGetSliceIdx returns the index of the num-th element if the dir is a
+// slice. The cached indexes are used until the slice is changed, or else the
+// indexes up to the element are read.
+func (c *Client) mdGetSliceIdx(num int64) (string, bool, error) {
+	kindKey := dir.Join(c.mdir, Config.MD.KindSubDir)
+	lenKey := dir.Join(c.mdir, Config.MD.LenSubDir)
+	resps, rev, err := c.stableRead(c.rev, func(rev int64) []Op {
+		return []Op{opGet(kindKey, rev), opGet(lenKey, rev)}
+	})
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
-	if num+1 > resp.Count {
-		return "", fmt.Errorf("order '%d' out of range on '%s'", num, c.odir)
+	kinds, lens := resps[0].Kvs, resps[1].Kvs
+	if len(kinds) == 0 || SKind(kinds[0].Value).ConvKind() != Slice {
+		return "", false, nil
 	}
-	key := string(resp.Kvs[num].Key)
-	idx := strings.TrimPrefix(key, idxesDir)
-	idx = strings.Trim(idx, "/")
-
-	return idx, nil
-}
-
-func (c *Client) mdGetString(fieldDir string) (string, error) {
-	key := dir.Join(c.mdir, fieldDir)
-	resp, err := c.Client.Get(c.ctx, key)
-	if err != nil {
-		return "", err
+	var length, lenRev int64
+	if len(lens) != 0 {
+		length, err = strconv.ParseInt(lens[0].Value, 10, 64)
+		if err != nil {
+			return "", false, err
+		}
+		lenRev = lens[0].ModRevision
 	}
-
-	if resp.Count == 0 {
-		return "", nil
+	if num < 0 || num >= length {
+		return "", false, fmt.Errorf("%s: %d on '%s' of length %d", ErrIndexOutOfRange, num, c.odir, length)
 	}
 
-	val := string(resp.Kvs[0].Value)
-	return val, nil
-}
+	if idxes := c.idxCache.get(c.mdir, lenRev); idxes != nil {
+		if num >= int64(len(idxes)) {
+			return "", false, fmt.Errorf("%s: %d on '%s' of length %d", ErrIndexOutOfRange, num, c.odir, len(idxes))
+		}
+		return idxes[num], true, nil
+	}
 
-func (c *Client) mdDirExists(fieldDir string) (bool, error) {
-	key := dir.Join(c.mdir, fieldDir)
-	resp, err := c.Client.Get(c.ctx, key, clientv3.WithCountOnly(), clientv3.WithLimit(1))
+	// the indexes up to the element, the whole indexes are cached
+	idxDir := dir.Join(c.mdir, Config.MD.IdxesSubDir)
+	op := opGetPrefix(idxDir, rev)
+	op.KeysOnly = true
+	op.Limit = num + 1
+	resp, err := c.backend.Do(c.ctx, op)
 	if err != nil {
-		return false, err
+		return "", false, err
 	}
-	if resp.Count != 1 {
-		return false, nil
+	if int64(len(resp.Kvs)) <= num {
+		return "", false, fmt.Errorf("%s: %d on '%s' of length %d", ErrIndexOutOfRange, num, c.odir, len(resp.Kvs))
 	}
-	return true, nil
-}
-
-func (c *Client) mdPutString(fieldDir, val string) (*clientv3.PutResponse, error) {
-	key := dir.Join(c.mdir, fieldDir)
-	return c.Client.Put(c.ctx, key, val)
-}
-
-func (c *Client) mdGetInt(fieldDir string) (int64, error) {
-	sv, err := c.mdGetString(fieldDir)
-	if err != nil {
-		return 0, err
+	idxes := make([]string, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		idxes[i] = strings.Trim(strings.TrimPrefix(kv.Key, idxDir), "/")
 	}
-	if sv == "" {
-		return 0, nil
+	if !resp.More {
+		c.idxCache.put(c.mdir, lenRev, idxes)
 	}
-
-	return strconv.ParseInt(sv, 10, 64)
-}
-
-func (c *Client) mdPutInt(fieldDir string, val int64) (*clientv3.PutResponse, error) {
-	return c.mdPutString(fieldDir, strconv.FormatInt(val, 10))
-}
-
-func (c *Client) mdIdxesDir() string {
-	return dir.Join(c.mdir, Config.MD.IdxesSubDir)
-}
-
-func (c *Client) mdIdxDir(idx string) string {
-	return dir.Join(c.mdIdxesDir(), idx)
-}
-
-func (c *Client) mdLenDir() string {
-	return dir.Join(c.mdir, Config.MD.LenSubDir)
+	return idxes[num], true, nil
 }
 
-func (c *Client) mdPutIdx(idx string) (*clientv3.PutResponse, error) {
-	idxSubDir := dir.Join(Config.MD.IdxesSubDir, idx)
-	return c.mdPutString(idxSubDir, idx)
-}
-
-func (c *Client) mdIdxExists(idx string) (bool, error) {
-	idxSubDir := dir.Join(Config.MD.IdxesSubDir, idx)
-	return c.mdDirExists(idxSubDir)
-}
+//
+// Pending
+//
 
-func (c *Client) mdPutKind(k Kind) (*clientv3.PutResponse, error) {
-	return c.mdPutString(Config.MD.KindSubDir, k.String())
+// pendingKey returns the pending marker key of the staged writes on rdir.
+func pendingKey(rdir string) string {
+	return dir.Join(Config.MD.PendingDir, rdir)
 }
 
-func (c *Client) mdPutLastID(id int64) (*clientv3.PutResponse, error) {
-	return c.mdPutString(Config.MD.LastIDSubDir, strconv.FormatInt(id, 10))
+// pendingAncestors returns the pending marker keys of the ancestors of rdir.
+func pendingAncestors(rdir string) []string {
+	depth := dir.Depth(rdir)
+	keys := make([]string, 0, depth)
+	for d := 1; d < depth; d++ {
+		keys = append(keys, pendingKey(dir.ParentD(rdir, d)))
+	}
+	return keys
+}
+
+// stableRev returns the revision to read the dir at rev (0 is the current
+// revision), which is before all the staged writes pending on the dir.
+func (c *Client) stableRev(rev int64) (int64, error) {
+	_, rev, err := c.stableRead(rev, nil)
+	return rev, err
+}
+
+// stableRead reads the ops at the stable revision of rev in one txn, and
+// returns their responses and the revision. ops builds the ops at a revision.
+func (c *Client) stableRead(rev int64, ops func(rev int64) []Op) ([]*Response, int64, error) {
+	key := pendingKey(c.rdir)
+	for {
+		var rops []Op
+		if ops != nil {
+			rops = ops(rev)
+		}
+		n := len(rops)
+		rops = append(rops, opGetPrefix(key, rev))
+		for _, akey := range pendingAncestors(c.rdir) {
+			rops = append(rops, opGet(akey, rev))
+		}
+		resp, err := c.backend.Txn(c.ctx, nil, rops, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		if rev == 0 {
+			rev = resp.Revision
+		}
+
+		stable := rev
+		for _, r := range resp.Responses[n:] {
+			for _, kv := range r.Kvs {
+				if kv.CreateRevision <= stable {
+					stable = kv.CreateRevision - 1
+				}
+			}
+		}
+		// an earlier revision may be in another staged write
+		if stable == rev {
+			return resp.Responses[:n], rev, nil
+		}
+		rev = stable
+	}
 }
 
-func (c *Client) mdPutLen(len int64) (*clientv3.PutResponse, error) {
-	return c.mdPutInt(Config.MD.LenSubDir, len)
+// sortByModRev sorts kvs by mod revision ascending, keeps the key order of the same revision.
+func sortByModRev(kvs []*KeyValue) {
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return kvs[i].ModRevision < kvs[j].ModRevision
+	})
 }
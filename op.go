@@ -3,15 +3,25 @@ package setcd
 import ()
 
 type Option struct {
-	tag      string // tag of a modify
-	eval     bool   // evaluate a dir
-	lock     bool   // put with lock
-	keysOnly bool   // only get keys
-	tagsOnly bool   // only get tags of first level dir
+	tag       string // tag of a modify
+	rev       int64  // revision of a read
+	tagForce  bool   // re-point the tag if it exists
+	eval      bool   // evaluate a dir
+	lock      bool   // put with lock, every put is transactional now
+	replace   bool   // put replaces the dir instead of merging into it
+	forceKind bool   // put replaces the dirs of another kind
+	keysOnly  bool   // only get keys
+	tagsOnly  bool   // only get tags of first level dir
 
 	evalTags     map[string]string
 	evalVarFmt   func(string) string
 	evalVarCheck func(string) error
+
+	tagMessage string // annotations of a new tag
+	tagAuthor  string
+	tagArchive bool // archive the tagged revision against compaction
+
+	bindErrFunc func(error) // report errors of a binding
 }
 
 type OpOption func(*Option)
@@ -20,6 +30,26 @@ func WithTag(tag string) OpOption {
 	return func(op *Option) { op.tag = tag }
 }
 
+func WithRevision(rev int64) OpOption {
+	return func(op *Option) { op.rev = rev }
+}
+
+func WithTagMessage(msg string) OpOption {
+	return func(op *Option) { op.tagMessage = msg }
+}
+
+func WithTagAuthor(author string) OpOption {
+	return func(op *Option) { op.tagAuthor = author }
+}
+
+func WithTagForce() OpOption {
+	return func(op *Option) { op.tagForce = true }
+}
+
+func WithTagArchive() OpOption {
+	return func(op *Option) { op.tagArchive = true }
+}
+
 func WithEval() OpOption {
 	return func(op *Option) { op.eval = true }
 }
@@ -28,6 +58,14 @@ func WithLock() OpOption {
 	return func(op *Option) { op.lock = true }
 }
 
+func WithReplace() OpOption {
+	return func(op *Option) { op.replace = true }
+}
+
+func WithForceKind() OpOption {
+	return func(op *Option) { op.forceKind = true }
+}
+
 func WithKeysOnly() OpOption {
 	return func(op *Option) { op.keysOnly = true }
 }
@@ -48,6 +86,10 @@ func WithTagsOnly() OpOption {
 	return func(op *Option) { op.tagsOnly = true }
 }
 
+func WithBindErrorFunc(f func(error)) OpOption {
+	return func(op *Option) { op.bindErrFunc = f }
+}
+
 func parseOption(oos []OpOption) *Option {
 	opt := &Option{}
 	for _, oo := range oos {
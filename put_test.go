@@ -0,0 +1,551 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/helloyi/setcd"
+	"github.com/helloyi/setcd/dir"
+)
+
+var _ = Describe("Put", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		backend setcd.Backend
+		cli     *setcd.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx, cancel = context.WithCancel(context.Background())
+		backend = setcd.NewMemoryBackend()
+		cli, err = setcd.NewWithBackend(backend, ctx, "/Put")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("all or nothing", func() {
+		err := cli.Put(map[string]interface{}{"a": "x", "b": make(chan int)})
+		Expect(err).To(HaveOccurred())
+
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: "/", End: "\x00", CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Count).To(BeZero())
+	})
+
+	Specify("struct", func() {
+		type server struct {
+			Host   string
+			Scheme string
+		}
+		err := cli.Put(server{Host: "a", Scheme: "http"})
+		Expect(err).NotTo(HaveOccurred())
+		err = cli.Put(&server{Host: "a", Scheme: "https"})
+		Expect(err).NotTo(HaveOccurred())
+
+		var out server
+		err = cli.GetStructVar(&out)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal(server{Host: "a", Scheme: "https"}))
+
+		keys, err := cli.Get(setcd.WithKeysOnly())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(ConsistOf("Host", "Scheme"))
+	})
+
+	Specify("struct tags", func() {
+		type Meta struct {
+			Owner string `setcd:"owner"`
+		}
+		type limits struct {
+			Rate int `setcd:"rate,string"`
+		}
+		type route struct {
+			Path    string  `setcd:"path"`
+			Timeout *int    `setcd:"timeout"`
+			Weight  float64 `setcd:"weight,omitempty"`
+		}
+		type service struct {
+			*Meta
+			Name    string           `setcd:"name"`
+			Limits  limits           `setcd:",inline"`
+			Routes  []route          `setcd:"routes"`
+			Backups map[string]route `setcd:"backups"`
+			Secret  string           `setcd:"-"`
+			note    string
+		}
+
+		timeout := 30
+		in := service{
+			Meta:   &Meta{Owner: "ops"},
+			Name:   "api",
+			Limits: limits{Rate: 100},
+			Routes: []route{{Path: "/a", Timeout: &timeout, Weight: 0.5}, {Path: "/b"}},
+			Backups: map[string]route{
+				"east": {Path: "/east"},
+			},
+			Secret: "x",
+			note:   "y",
+		}
+		Expect(cli.Put(in)).To(Succeed())
+
+		keys, err := cli.Get(setcd.WithKeysOnly())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(ConsistOf("owner", "name", "rate", "routes", "backups"))
+		rc, err := cli.ShadowClone("rate")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Get()).To(Equal("100"))
+		bc, err := cli.ShadowClone("routes/1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bc.Get(setcd.WithKeysOnly())).To(ConsistOf("path"))
+
+		out := service{Secret: "kept", note: "kept"}
+		Expect(cli.GetStructVar(&out)).To(Succeed())
+		in.Secret, in.note = "kept", "kept"
+		Expect(out).To(Equal(in))
+		Expect(out.Routes[1].Timeout).To(BeNil())
+	})
+
+	Specify("old struct tags", func() {
+		type tls struct {
+			Cert string `mapstructure:"cert"`
+		}
+		type server struct {
+			Host string `structs:"host" mapstructure:"hostname"`
+			Port int    `mapstructure:"port,omitempty"`
+			TLS  tls    `mapstructure:",squash"`
+			Mode string `setcd:"mode" structs:"-"`
+			Skip string `structs:"-"`
+		}
+
+		in := server{Host: "a", TLS: tls{Cert: "x"}, Mode: "m", Skip: "s"}
+		Expect(cli.Put(in)).To(Succeed())
+		Expect(cli.Get()).To(Equal(map[string]interface{}{"host": "a", "cert": "x", "mode": "m"}))
+
+		var out server
+		Expect(cli.GetStructVar(&out)).To(Succeed())
+		in.Skip = ""
+		Expect(out).To(Equal(in))
+	})
+
+	Specify("marshalers", func() {
+		type job struct {
+			At       time.Time     `setcd:"at"`
+			Interval time.Duration `setcd:"interval"`
+			Addr     net.IP        `setcd:"addr"`
+			Endpoint url.URL       `setcd:"endpoint"`
+			Level    level         `setcd:"level"`
+			Backoff  *backoff      `setcd:"backoff"`
+		}
+		at := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+		endpoint, err := url.Parse("https://example.com/hook?x=1")
+		Expect(err).NotTo(HaveOccurred())
+		in := job{
+			At:       at,
+			Interval: 90 * time.Second,
+			Addr:     net.ParseIP("10.0.0.1"),
+			Endpoint: *endpoint,
+			Level:    levelWarn,
+			Backoff:  &backoff{Base: time.Second, Max: time.Minute},
+		}
+		Expect(cli.Put(in)).To(Succeed())
+
+		res, err := cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(map[string]interface{}{
+			"at":       "2020-01-02T03:04:05.000000006Z",
+			"interval": "1m30s",
+			"addr":     "10.0.0.1",
+			"endpoint": "https://example.com/hook?x=1",
+			"level":    "warn",
+			"backoff":  "1s..1m0s",
+		}))
+
+		var out job
+		Expect(cli.GetStructVar(&out)).To(Succeed())
+		Expect(out.At.Equal(at)).To(BeTrue())
+		out.At = at
+		Expect(out).To(Equal(in))
+	})
+
+	Specify("nil and empty", func() {
+		var none *struct{}
+		data := map[string]interface{}{
+			"null":  nil,
+			"none":  none,
+			"map":   map[string]interface{}{},
+			"slice": []interface{}{},
+			"list":  []interface{}{nil, "x"},
+		}
+		Expect(cli.Put(data)).To(Succeed())
+		Expect(cli.Get()).To(Equal(map[string]interface{}{
+			"null":  nil,
+			"none":  nil,
+			"map":   map[string]interface{}{},
+			"slice": []interface{}{},
+			"list":  []interface{}{nil, "x"},
+		}))
+
+		mc, err := cli.ShadowClone("map")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mc.Get()).To(Equal(map[string]interface{}{}))
+		Expect(mc.Put(map[string]interface{}{"a": "x"})).To(Succeed())
+		ac, err := mc.ShadowClone("a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ac.Delete()).To(Succeed())
+		Expect(mc.Get()).To(Equal(map[string]interface{}{}))
+
+		// nil replaces a scale, a container only by force
+		nc, err := cli.ShadowClone("null")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nc.Put("y")).To(Succeed())
+		Expect(nc.Get()).To(Equal("y"))
+		Expect(nc.Put(nil)).To(Succeed())
+		Expect(nc.Get()).To(BeNil())
+		_, err = nc.GetString()
+		Expect(err).To(HaveOccurred())
+		Expect(mc.Put(nil)).NotTo(Succeed())
+		Expect(mc.Put(nil, setcd.WithForceKind())).To(Succeed())
+		Expect(cli.Get()).To(HaveKeyWithValue("map", BeNil()))
+	})
+
+	Specify("reserved keys", func() {
+		data := map[string]interface{}{
+			"a/b":                 "slash",
+			"..":                  "parent",
+			"":                    "empty",
+			"__idxes__":           "metadata",
+			"https://x.io/p?q=%2": map[string]interface{}{"/": "root"},
+		}
+		Expect(cli.Put(data)).To(Succeed())
+		Expect(cli.Get()).To(Equal(data))
+		Expect(cli.Get(setcd.WithKeysOnly())).To(ConsistOf("a/b", "..", "", "__idxes__", "https://x.io/p?q=%2"))
+
+		keys := []string{}
+		Expect(cli.DoMap(func(key string, _ interface{}) bool {
+			keys = append(keys, key)
+			return true
+		})).To(Succeed())
+		Expect(keys).To(ConsistOf("a/b", "..", "", "__idxes__", "https://x.io/p?q=%2"))
+
+		uc, err := cli.ShadowClone(dir.EscapeKey("https://x.io/p?q=%2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uc.Get()).To(Equal(map[string]interface{}{"/": "root"}))
+		Expect(uc.Put(map[string]interface{}{"/": "new"})).To(Succeed())
+		data["https://x.io/p?q=%2"] = map[string]interface{}{"/": "new"}
+		sc, err := cli.ShadowClone(dir.EscapeKey("a/b"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sc.Delete()).To(Succeed())
+		delete(data, "a/b")
+
+		// branches of a dir are escaped as keys
+		pc, err := cli.ShadowClone("100%")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pc.Put(map[string]interface{}{"__x": "x"})).To(Succeed())
+		uc, err = cli.ShadowClone("100%/__x")
+		Expect(err).NotTo(HaveOccurred())
+		ic, err := cli.ShadowClone("__idxes__")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ic.Put("changed")).To(Succeed())
+		data["100%"] = map[string]interface{}{"__x": "x"}
+		data["__idxes__"] = "changed"
+		Expect(cli.Get()).To(Equal(data))
+		Expect(uc.Get()).To(Equal("x"))
+
+		// nothing escapes the dir
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: "/", End: "/Put/", CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Count).To(BeZero())
+	})
+
+	Specify("non-string keys", func() {
+		type cluster struct {
+			Ports  map[int]string    `setcd:"ports"`
+			Shards map[uint8]bool    `setcd:"shards"`
+			Flags  map[bool]string   `setcd:"flags"`
+			Levels map[level]float64 `setcd:"levels"`
+		}
+		in := cluster{
+			Ports:  map[int]string{80: "http", 443: "https"},
+			Shards: map[uint8]bool{0: true, 255: false},
+			Flags:  map[bool]string{true: "on"},
+			Levels: map[level]float64{levelInfo: 0.5, levelWarn: 1},
+		}
+		Expect(cli.Put(in)).To(Succeed())
+
+		pc, err := cli.ShadowClone("ports")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pc.Get()).To(Equal(map[string]interface{}{"80": "http", "443": "https"}))
+		var ports interface{}
+		Expect(pc.Decode(&ports)).To(Succeed())
+		Expect(ports).To(Equal(map[int64]interface{}{80: "http", 443: "https"}))
+
+		var out cluster
+		Expect(cli.Decode(&out)).To(Succeed())
+		Expect(out).To(Equal(in))
+
+		// the keys of a map keep their type
+		err = pc.Put(map[string]string{"http": "80"})
+		Expect(err).To(HaveOccurred())
+		Expect(pc.Put(map[int16]string{8080: "alt"})).To(Succeed())
+
+		Expect(pc.PutMap(map[string]interface{}{"22": "ssh"}, setcd.WithTag("ssh"), setcd.WithReplace())).To(Succeed())
+		Expect(pc.Get()).To(Equal(map[string]interface{}{"22": "ssh"}))
+		Expect(pc.Get(setcd.WithTag("ssh"))).To(Equal(map[string]interface{}{"22": "ssh"}))
+	})
+
+	Specify("replace", func() {
+		err := cli.Put(map[string]interface{}{
+			"a": "x",
+			"b": "y",
+			"s": []interface{}{"p", "q", "r"},
+		}, setcd.WithTag("v1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		data := map[string]interface{}{
+			"a": "z",
+			"s": []interface{}{"w"},
+		}
+		err = cli.Put(data, setcd.WithReplace())
+		Expect(err).NotTo(HaveOccurred())
+
+		res, err := cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(data))
+
+		keys, err := cli.Get(setcd.WithKeysOnly())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(ConsistOf("a", "s"))
+
+		// the tags are kept
+		tags, err := cli.Get(setcd.WithTagsOnly())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(Equal([]string{"v1"}))
+	})
+
+	Specify("force kind", func() {
+		err := cli.Put(map[string]interface{}{"a": "x", "b": map[string]interface{}{"c": "y"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		data := map[string]interface{}{"b": []interface{}{"p", "q"}}
+		err = cli.Put(data)
+		Expect(err).To(HaveOccurred())
+
+		err = cli.Put(data, setcd.WithForceKind())
+		Expect(err).NotTo(HaveOccurred())
+
+		res, err := cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(map[string]interface{}{"a": "x", "b": []interface{}{"p", "q"}}))
+
+		err = cli.Put("z", setcd.WithForceKind())
+		Expect(err).NotTo(HaveOccurred())
+
+		res, err = cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal("z"))
+	})
+
+	Specify("staged", func() {
+		defer func(n int) { setcd.Config.MaxTxnOps = n }(setcd.Config.MaxTxnOps)
+		setcd.Config.MaxTxnOps = 8
+
+		data := make(map[string]interface{})
+		for i := 0; i < 20; i++ {
+			data["k"+strconv.Itoa(i)] = "v" + strconv.Itoa(i)
+		}
+
+		// the chunks of a staged put are seen as one change
+		wch := cli.Watch(ctx)
+		err := cli.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+
+		ev := <-wch
+		Expect(ev.Err).NotTo(HaveOccurred())
+		Expect(ev.Value).To(Equal(data))
+		Expect(ev.Changes).To(Equal([]setcd.Change{{Path: "/Put/", Type: setcd.Added, New: data}}))
+
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: setcd.Config.MD.PendingDir + "/", End: setcd.Config.MD.PendingDir + "0", CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Count).To(BeZero())
+	})
+
+	Specify("failed staged", func() {
+		defer func(n int, d time.Duration) {
+			setcd.Config.MaxTxnOps, setcd.Config.PendingTimeout = n, d
+		}(setcd.Config.MaxTxnOps, setcd.Config.PendingTimeout)
+		setcd.Config.MaxTxnOps = 8
+
+		fb := &failingBackend{Backend: backend}
+		fc, err := setcd.NewWithBackend(fb, ctx, "/Put")
+		Expect(err).NotTo(HaveOccurred())
+
+		values := func(v string) map[string]interface{} {
+			data := make(map[string]interface{})
+			for i := 0; i < 20; i++ {
+				data["k"+strconv.Itoa(i)] = v + strconv.Itoa(i)
+			}
+			return data
+		}
+		Expect(fc.Put(values("a"))).To(Succeed())
+
+		// a failed chunk aborts the write
+		fb.fail(2, 2)
+		Expect(fc.Put(values("b"))).To(MatchError("txn failed"))
+		fb.fail(0, 0)
+		Expect(cli.Get()).To(Equal(values("a")))
+		Expect(cli.Put(values("c"))).To(Succeed())
+		Expect(cli.Get()).To(Equal(values("c")))
+
+		want := values("c")
+		want["d"] = values("d")
+		Expect(cli.Put(map[string]interface{}{"d": values("d")})).To(Succeed())
+
+		// a failed abort leaves the marker, which the next writer waits for,
+		// and takes over once it is stale
+		dc, err := fc.ShadowClone("d")
+		Expect(err).NotTo(HaveOccurred())
+		fb.fail(2, 3)
+		Expect(dc.Delete()).To(MatchError(ContainSubstring("abort")))
+		fb.fail(0, 0)
+		Expect(cli.Get()).To(Equal(want))
+		seen := make(map[string]interface{})
+		Expect(cli.Do(func(k string, v interface{}) bool {
+			seen[k] = v
+			return true
+		})).To(Succeed())
+		Expect(seen).To(Equal(want))
+
+		// the commit time is put by the last chunk only
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: setcd.Config.MD.TimeDir + "/Put/d/"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Kvs).To(BeEmpty())
+
+		wctx, wcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer wcancel()
+		wc, err := setcd.NewWithBackend(backend, wctx, "/Put")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(wc.Put(values("e"))).To(MatchError(ContainSubstring(setcd.ErrPendingWrite.Error())))
+
+		// the metadata of the parent is rolled back with the dir
+		setcd.Config.PendingTimeout = 100 * time.Millisecond
+		Expect(cli.Put(map[string]interface{}{"k0": "z"})).To(Succeed())
+		want["k0"] = "z"
+		Expect(cli.Get()).To(Equal(want))
+		Expect(cli.Get(setcd.WithKeysOnly())).To(HaveLen(21))
+
+		resp, err = backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: setcd.Config.MD.PendingDir + "/", End: setcd.Config.MD.PendingDir + "0", CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Count).To(BeZero())
+	})
+
+	Specify("dir named __pending__", func() {
+		pc, err := setcd.NewWithBackend(backend, ctx, "/__pending__")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pc.Put(map[string]interface{}{"Put": "x", "__kind__": "y"})).To(Succeed())
+
+		kc, err := setcd.NewWithBackend(backend, ctx, "/__kind__")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cli.Put("a")).To(Succeed())
+		Expect(kc.Put("b")).To(Succeed())
+		Expect(pc.Get()).To(Equal(map[string]interface{}{"Put": "x", "__kind__": "y"}))
+
+		// first-level dirs keep their names
+		resp, err := backend.Do(ctx, setcd.Op{Type: setcd.OpGet, Key: "/__pending__/", End: "/__pending__0", CountOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Count).NotTo(BeZero())
+	})
+})
+
+// failingBackend fails the n-th write txns, from the first to the last.
+type failingBackend struct {
+	setcd.Backend
+	writes      int32
+	first, last int32
+}
+
+func (b *failingBackend) fail(first, last int32) {
+	atomic.StoreInt32(&b.writes, 0)
+	atomic.StoreInt32(&b.first, first)
+	atomic.StoreInt32(&b.last, last)
+}
+
+func (b *failingBackend) Txn(ctx context.Context, cmps []setcd.Cmp, thenOps, elseOps []setcd.Op) (*setcd.TxnResponse, error) {
+	if len(thenOps) != 0 && thenOps[0].Type != setcd.OpGet {
+		n := atomic.AddInt32(&b.writes, 1)
+		if n >= atomic.LoadInt32(&b.first) && n <= atomic.LoadInt32(&b.last) {
+			return nil, errors.New("txn failed")
+		}
+	}
+	return b.Backend.Txn(ctx, cmps, thenOps, elseOps)
+}
+
+type level int
+
+const (
+	levelInfo level = iota
+	levelWarn
+)
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case levelInfo:
+		return []byte("info"), nil
+	case levelWarn:
+		return []byte("warn"), nil
+	}
+	return nil, fmt.Errorf("invalid level %d", l)
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "info":
+		*l = levelInfo
+	case "warn":
+		*l = levelWarn
+	default:
+		return fmt.Errorf("invalid level '%s'", text)
+	}
+	return nil
+}
+
+type backoff struct {
+	Base, Max time.Duration
+}
+
+func (b *backoff) MarshalSetcd() (interface{}, error) {
+	return b.Base.String() + ".." + b.Max.String(), nil
+}
+
+func (b *backoff) UnmarshalSetcd(value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid backoff '%v'", value)
+	}
+	parts := strings.SplitN(s, "..", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid backoff '%s'", s)
+	}
+	var err error
+	if b.Base, err = time.ParseDuration(parts[0]); err != nil {
+		return err
+	}
+	b.Max, err = time.ParseDuration(parts[1])
+	return err
+}
@@ -0,0 +1,73 @@
+package setcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/helloyi/setcd/dir"
+)
+
+// Rollback restores the dir to the revision of the tag in one transaction.
+//
+// The data and metadata are written back as they were, so the ids of slice
+// elements are kept. WithTag tags the restored revision.
+func (c *Client) Rollback(tag string, oos ...OpOption) error {
+	rc, err := c.tagged(tag)
+	if err != nil {
+		return err
+	}
+	kvs, md, err := rc.readDir(rc.rev)
+	if err != nil {
+		return err
+	}
+
+	return c.update(parseOption(oos), func(s *STM) error {
+		return s.restore(kvs, md)
+	})
+}
+
+// restore replaces the dir by the data and metadata read at another revision.
+func (s *STM) restore(kvs []*KeyValue, md kvMeta) error {
+	if len(kvs) == 0 {
+		if s.mdGetKind() == Nil {
+			return nil
+		}
+		return s.delete()
+	}
+
+	// the dir may have been deleted from its parent
+	if dir.Depth(s.rdir) > 1 {
+		ps, err := s.shadowClone("../", "../")
+		if err != nil {
+			return err
+		}
+
+		switch kind := ps.mdGetKind(); kind {
+		case Slice, Map:
+			idx := dir.SubD(s.rdir, 1)
+			if !ps.mdIdxExists(idx) {
+				length, err := ps.mdGetLen()
+				if err != nil {
+					return err
+				}
+				ps.mdPutIdx(idx)
+				ps.mdPutLen(length + 1)
+			}
+		default:
+			return fmt.Errorf("%s: restore '%s' into '%s'", ErrInvalidOperation, s.odir, kind)
+		}
+	}
+
+	s.clear()
+	for _, kv := range kvs {
+		s.stm.Put(kv.Key, kv.Value)
+	}
+	legacy := dir.Depth(s.rdir) == 1
+	for key, val := range md {
+		if legacy && strings.HasPrefix(key, legacyTagDir(s.rdir)) {
+			continue
+		}
+		s.stm.Put(key, val)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Rollback", func() {
+	var cli *setcd.Client
+
+	v1 := map[string]interface{}{
+		"host":   "a",
+		"routes": []interface{}{"api", "web", "admin"},
+	}
+
+	BeforeEach(func() {
+		var err error
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), context.Background(), "/Rollback")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(v1, setcd.WithTag("v1"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("restores the tagged revision", func() {
+		rc, err := cli.ShadowClone("routes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.RemoveAt(0)).To(Succeed())
+		Expect(rc.InsertAt(1, "docs")).To(Succeed())
+		err = cli.Put(map[string]interface{}{"host": "b", "tls": "on"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cli.Rollback("v1", setcd.WithTag("rollback-v1"))).To(Succeed())
+		Expect(cli.Get()).To(Equal(v1))
+
+		ec, err := cli.ShadowClone("routes/2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ec.Get()).To(Equal("admin"))
+
+		Expect(cli.Get(setcd.WithTag("rollback-v1"))).To(Equal(v1))
+		tags, err := cli.Get(setcd.WithTagsOnly())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(Equal([]string{"v1", "rollback-v1"}))
+	})
+
+	Specify("restores a deleted child", func() {
+		rc, err := cli.ShadowClone("routes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Delete()).To(Succeed())
+		Expect(cli.Get(setcd.WithKeysOnly())).To(Equal([]string{"host"}))
+
+		Expect(rc.Rollback("v1")).To(Succeed())
+		Expect(cli.Get()).To(Equal(v1))
+		Expect(cli.Get(setcd.WithKeysOnly())).To(ConsistOf("host", "routes"))
+	})
+})
@@ -1,46 +1,252 @@
 package setcd
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
-	"github.com/coreos/etcd/clientv3"
+	"github.com/helloyi/setcd/dir"
 )
 
-func (s *STM) putString(sv string) error {
+// types of a scale in its metadata, a scale without a type is parsed by the
+// format of its value.
+const (
+	scaleInt    = "int64"
+	scaleUint   = "uint64"
+	scaleFloat  = "float64"
+	scaleBool   = "bool"
+	scaleString = "string"
+
+	scaleBigInt   = "bigint"
+	scaleBigFloat = "bigfloat" // with the precision, as 'bigfloat:<prec>'
+	scaleNumber   = "number"   // decimal string of a json.Number
+	scaleBytes    = "bytes"    // binary value of a []byte
+)
+
+// maxExactInt is the max integer that float64 holds exactly.
+const maxExactInt = 1 << 53
+
+// putScale puts the value of a scale and its type.
+func (s *STM) putScale(sv, typ string) error {
 	kind := s.mdGetKind()
 	if kind != Nil && kind != Scale {
-		return fmt.Errorf("invalid scale type on '%s'", s.odir)
+		if !s.forceKind {
+			return fmt.Errorf("invalid scale type on '%s'", s.odir)
+		}
+		s.clear()
+	}
+	if s.mdGetString(Config.MD.KindSubDir) == SNil {
+		s.stm.Del(dir.Join(s.mdir, Config.MD.KindSubDir))
 	}
 	s.stm.Put(s.rdir, sv)
-	return nil
+	return s.mdPutString(Config.MD.TypeSubDir, typ)
+}
+
+func (s *STM) putString(sv string) error {
+	return s.putScale(sv, scaleString)
 }
 
 func (s *STM) putBool(bv bool) error {
-	return s.putString(strconv.FormatBool(bv))
+	return s.putScale(strconv.FormatBool(bv), scaleBool)
 }
 
 func (s *STM) putInt(iv int64) error {
-	return s.putString(strconv.FormatInt(iv, 10))
+	return s.putScale(strconv.FormatInt(iv, 10), scaleInt)
 }
 
 func (s *STM) putUint(uv uint64) error {
-	return s.putString(strconv.FormatUint(uv, 10))
+	return s.putScale(strconv.FormatUint(uv, 10), scaleUint)
+}
+
+func (s *STM) putFloat(fv float64, bitSize int) error {
+	return s.putScale(strconv.FormatFloat(fv, 'g', -1, bitSize), scaleFloat)
+}
+
+func (s *STM) putBigInt(bi *big.Int) error {
+	return s.putScale(bi.String(), scaleBigInt)
+}
+
+func (s *STM) putBigFloat(bf *big.Float) error {
+	typ := fmt.Sprintf("%s:%d", scaleBigFloat, bf.Prec())
+	return s.putScale(bf.Text('g', -1), typ)
+}
+
+func (s *STM) putNumber(n json.Number) error {
+	if _, err := parseBigFloat(n.String(), ""); err != nil {
+		return err
+	}
+	return s.putScale(n.String(), scaleNumber)
+}
+
+func (s *STM) putBytes(bv []byte) error {
+	return s.putScale(string(bv), scaleBytes)
+}
+
+// parseScale parses the value of a scale of type typ.
+func parseScale(value, typ string) (interface{}, error) {
+	switch typ {
+	case scaleInt:
+		return strconv.ParseInt(value, 10, 64)
+	case scaleUint:
+		return strconv.ParseUint(value, 10, 64)
+	case scaleFloat:
+		return strconv.ParseFloat(value, 64)
+	case scaleBool:
+		return strconv.ParseBool(value)
+	case scaleString:
+		return value, nil
+	case scaleBigInt:
+		bi, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid big.Int '%s'", value)
+		}
+		return bi, nil
+	case scaleNumber:
+		return json.Number(value), nil
+	case scaleBytes:
+		return []byte(value), nil
+	}
+	if strings.HasPrefix(typ, scaleBigFloat) {
+		return parseBigFloat(value, typ)
+	}
+
+	// integers which float64 does not hold exactly
+	if iv, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if iv > maxExactInt || iv < -maxExactInt {
+			return iv, nil
+		}
+	} else if uv, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return uv, nil
+	} else if bi, ok := new(big.Int).SetString(value, 10); ok {
+		return bi, nil
+	}
+
+	// priority parse float
+	if fv, err := strconv.ParseFloat(value, 64); err == nil {
+		return fv, nil
+	}
+	// then bool
+	if bv, err := strconv.ParseBool(value); err == nil {
+		return bv, nil
+	}
+	// must string
+	return value, nil
 }
 
-func (s *STM) putFloat(fv float64) error {
-	// TODO:
-	// precision of fv
-	return s.putString(strconv.FormatFloat(fv, 'E', -1, 64))
+// parseBigFloat parses a number at the precision of its type, or at a
+// precision which holds all its digits.
+func parseBigFloat(value, typ string) (*big.Float, error) {
+	prec := uint(4 * len(value))
+	if prec < 64 {
+		prec = 64
+	}
+	if p := strings.TrimPrefix(typ, scaleBigFloat+":"); p != typ {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid big.Float type '%s'", typ)
+		}
+		prec = uint(n)
+	}
+
+	bf, _, err := big.ParseFloat(value, 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number '%s': %s", value, err)
+	}
+	return bf, nil
 }
 
 //------------
 // get function
 //------------
 
+// getScale reads the scale key of the dir, which has no kvs if the dir is not
+// a scale.
+func (c *Client) getScale() (*Response, error) {
+	kindKey := dir.Join(c.mdir, Config.MD.KindSubDir)
+	resps, _, err := c.stableRead(c.rev, func(rev int64) []Op {
+		return []Op{opGet(c.rdir, rev), opGet(kindKey, rev)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the key of a nil, map or slice
+	if len(resps[1].Kvs) != 0 {
+		return &Response{Revision: resps[0].Revision}, nil
+	}
+	return resps[0], nil
+}
+
+// getScaleType reads the value of the scale and its type, the type is "" for
+// an untyped scale.
+func (c *Client) getScaleType() (string, string, error) {
+	typeKey := dir.Join(c.mdir, Config.MD.TypeSubDir)
+	kindKey := dir.Join(c.mdir, Config.MD.KindSubDir)
+	resps, _, err := c.stableRead(c.rev, func(rev int64) []Op {
+		return []Op{opGet(c.rdir, rev), opGet(typeKey, rev), opGet(kindKey, rev)}
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if len(resps[0].Kvs) == 0 || len(resps[2].Kvs) != 0 {
+		return "", "", fmt.Errorf("invalid scale type on '%s'", c.odir)
+	}
+
+	var typ string
+	if kvs := resps[1].Kvs; len(kvs) != 0 {
+		typ = kvs[0].Value
+	}
+	return resps[0].Kvs[0].Value, typ, nil
+}
+
+// GetBigInt ...
+func (c *Client) GetBigInt() (*big.Int, error) {
+	value, _, err := c.getScaleType()
+	if err != nil {
+		return nil, err
+	}
+	bi, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid big.Int type on '%s'", c.odir)
+	}
+	return bi, nil
+}
+
+// GetBigFloat returns the number at the precision it was put, or at a
+// precision which holds all its digits.
+func (c *Client) GetBigFloat() (*big.Float, error) {
+	value, typ, err := c.getScaleType()
+	if err != nil {
+		return nil, err
+	}
+	return parseBigFloat(value, typ)
+}
+
+// GetNumber returns the number as its decimal string.
+func (c *Client) GetNumber() (json.Number, error) {
+	value, _, err := c.getScaleType()
+	if err != nil {
+		return "", err
+	}
+	if _, err := parseBigFloat(value, ""); err != nil {
+		return "", err
+	}
+	return json.Number(value), nil
+}
+
+// GetBytes returns the binary value of the scale.
+func (c *Client) GetBytes() ([]byte, error) {
+	value, _, err := c.getScaleType()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
 // GetBool ...
 func (c *Client) GetBool() (bool, error) {
-	getResp, err := c.Client.Get(c.ctx, c.rdir)
+	getResp, err := c.getScale()
 	if err != nil {
 		return false, err
 	}
@@ -48,7 +254,7 @@ func (c *Client) GetBool() (bool, error) {
 		return false, fmt.Errorf("invalid bool type on %s", c.odir)
 	}
 
-	value := string(getResp.Kvs[0].Value)
+	value := getResp.Kvs[0].Value
 	bv, err := strconv.ParseBool(value)
 	if err != nil {
 		return false, err
@@ -68,14 +274,14 @@ func (c *Client) GetBoolVar(bp *bool) error {
 
 // GetInt ...
 func (c *Client) getInt(base int, bitSize int) (int64, error) {
-	getResp, err := c.Client.Get(c.ctx, c.rdir)
+	getResp, err := c.getScale()
 	if err != nil {
 		return 0, err
 	}
 	if getResp.Count != 1 {
 		return 0, fmt.Errorf("invalid path")
 	}
-	value := string(getResp.Kvs[0].Value)
+	value := getResp.Kvs[0].Value
 	iv, err := strconv.ParseInt(value, base, bitSize)
 	if err != nil {
 		return 0, err
@@ -160,14 +366,14 @@ func (c *Client) GetInt64Var(ip *int64) (err error) {
 
 // GetUint ...
 func (c *Client) getUint(base int, bitSize int) (uint64, error) {
-	getResp, err := c.Client.Get(c.ctx, c.rdir)
+	getResp, err := c.getScale()
 	if err != nil {
 		return 0, err
 	}
 	if getResp.Count != 1 {
 		return 0, fmt.Errorf("invalid path")
 	}
-	value := string(getResp.Kvs[0].Value)
+	value := getResp.Kvs[0].Value
 	iv, err := strconv.ParseUint(value, base, bitSize)
 	if err != nil {
 		return 0, err
@@ -252,14 +458,14 @@ func (c *Client) GetUint64Var(ip *uint64) (err error) {
 
 // getFloat ...
 func (c *Client) getFloat(bitSize int) (float64, error) {
-	resp, err := c.Client.Get(c.ctx, c.rdir)
+	resp, err := c.getScale()
 	if err != nil {
 		return 0, err
 	}
 	if resp.Count == 0 {
 		return 0, fmt.Errorf("invalid path")
 	}
-	value := string(resp.Kvs[0].Value)
+	value := resp.Kvs[0].Value
 	fv, err := strconv.ParseFloat(value, bitSize)
 	if err != nil {
 		return 0, err
@@ -292,14 +498,14 @@ func (c *Client) GetFloat64Var(fp *float64) (err error) {
 
 // GetString ...
 func (c *Client) GetString() (string, error) {
-	resp, err := c.Client.Get(c.ctx, c.rdir)
+	resp, err := c.getScale()
 	if err != nil {
 		return "", err
 	}
 	if resp.Count == 0 {
 		return "", fmt.Errorf("invalid dir")
 	}
-	value := string(resp.Kvs[0].Value)
+	value := resp.Kvs[0].Value
 	return value, nil
 }
 
@@ -308,44 +514,3 @@ func (c *Client) GetStringVar(sp *string) (err error) {
 	*sp, err = c.GetString()
 	return
 }
-
-// -------------------------------------------------------------------------------------
-// -------------------------------------------------------------------------------------
-// -----------------------------put functions-------------------------------------------
-// -------------------------------------------------------------------------------------
-// -------------------------------------------------------------------------------------
-
-// putString put string value to 'c.odir'
-func (c *Client) putString(sv string) (*clientv3.PutResponse, error) {
-	kind, err := c.mdGetKind()
-	if err != nil {
-		return nil, err
-	}
-	if kind != Nil && kind != Scale {
-		return nil, fmt.Errorf("invalid scale type on '%s'", c.odir)
-	}
-	return c.Client.Put(c.ctx, c.rdir, sv)
-}
-
-// putBool put bool value to 'c.odir'
-func (c *Client) putBool(bv bool) (*clientv3.PutResponse, error) {
-	return c.putString(strconv.FormatBool(bv))
-}
-
-// putInt put int64 value to 'c.odir'
-func (c *Client) putInt(iv int64) (*clientv3.PutResponse, error) {
-	return c.putString(strconv.FormatInt(iv, 10))
-}
-
-// putUint put uint64 value to 'c.odir'
-func (c *Client) putUint(uv uint64) (*clientv3.PutResponse, error) {
-	return c.putString(strconv.FormatUint(uv, 10))
-}
-
-// putFloat put float64 value to 'c.odir'
-//
-// TODO:
-// precision of fv
-func (c *Client) putFloat(fv float64) (*clientv3.PutResponse, error) {
-	return c.putString(strconv.FormatFloat(fv, 'E', -1, 64))
-}
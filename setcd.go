@@ -4,30 +4,51 @@
 package setcd
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/clientv3/concurrency"
 	"github.com/helloyi/setcd/dir"
 )
 
 // Client provides and manages an mapetcd client session.
 type Client struct {
-	*clientv3.Client // etcd clientv3
+	backend  Backend   // storage backend
+	idxCache *idxCache // indexes of slices, shared by the clones
 
-	ctx  context.Context // etcd context
+	ctx  context.Context // backend context
+	rev  int64           // read revision, 0 means the current revision
 	odir string          // dir of user interface
 	rdir string          // real path
 	mdir string          // metadata path
+
+	typedKeys bool // parse the keys of maps by their key types
 }
 
 // New creates a new mapetcd client
 func New(cfg clientv3.Config, ctx context.Context, directory string) (*Client, error) {
+	etcdClient, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewWithBackend(NewEtcdBackend(etcdClient), ctx, directory)
+	if err != nil {
+		etcdClient.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewWithBackend creates a new mapetcd client on a storage backend
+func NewWithBackend(backend Backend, ctx context.Context, directory string) (*Client, error) {
 	if !dir.IsAbs(directory) {
 		return nil, ErrNotAbsoluteDir
 	}
@@ -40,14 +61,10 @@ func New(cfg clientv3.Config, ctx context.Context, directory string) (*Client, e
 		return nil, fmt.Errorf("%s: '%s'", ErrNotAllowedDir, Config.MD.RootDir)
 	}
 
-	etcdClient, err := clientv3.New(cfg)
-	if err != nil {
-		return nil, err
-	}
-
 	c := &Client{
-		Client: etcdClient,
-		ctx:    ctx,
+		backend:  backend,
+		idxCache: newIdxCache(),
+		ctx:      ctx,
 	}
 
 	rdir, err := c.realDir("/", "/", odir)
@@ -63,9 +80,14 @@ func New(cfg clientv3.Config, ctx context.Context, directory string) (*Client, e
 	return c, nil
 }
 
-// Close shuts down the client's etcd connections.
+// Close shuts down the client's backend.
 func (c *Client) Close() error {
-	return c.Client.Close()
+	return c.backend.Close()
+}
+
+// Backend returns the storage backend of the client.
+func (c *Client) Backend() Backend {
+	return c.backend
 }
 
 // ShadowClone "The Shadow Clone Jutsu"
@@ -85,8 +107,10 @@ func (c *Client) ShadowClone(directory string) (*Client, error) {
 	}
 
 	sc := &Client{
-		Client: c.Client,
-		ctx:    c.ctx,
+		backend:  c.backend,
+		idxCache: c.idxCache,
+		ctx:      c.ctx,
+		rev:      c.rev,
 	}
 
 	newRdir, err := sc.realDir(c.rdir, c.odir, newOdir)
@@ -112,157 +136,75 @@ func (c *Client) Get(oos ...OpOption) (interface{}, error) {
 	opt := parseOption(oos)
 
 	if opt.tagsOnly {
-		return c.mdGetTags()
-	}
-
-	etcdOpts := make([]clientv3.OpOption, 0)
-	if opt.tag != "" {
-		rev, err := c.mdGetRev(opt.tag)
+		tags, err := c.mdGetTags()
 		if err != nil {
 			return nil, err
 		}
-		etcdOpts = append(etcdOpts, clientv3.WithRev(rev))
-	}
-
-	if opt.keysOnly {
-		return c.mdGetIdxes(etcdOpts...)
+		names := make([]string, len(tags))
+		for i, t := range tags {
+			names[i] = t.Name
+		}
+		return names, nil
 	}
 
-	etcdOpts = append(etcdOpts, clientv3.WithPrefix(),
-		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
-	resp, err := c.Client.Get(c.ctx, c.rdir, etcdOpts...)
+	rc, err := c.reader(opt)
 	if err != nil {
 		return nil, err
 	}
-	ret, err := c.kvParse(resp.Kvs)
 
-	if opt.eval {
-		return c.eval(ret, opt.evalTags, opt.evalVarFmt, opt.evalVarCheck)
+	if opt.keysOnly {
+		rev, err := rc.stableRev(rc.rev)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := rc.mdGetIdxes(rev, Map)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			keys[i] = dir.UnescapeKey(key)
+		}
+		return keys, nil
 	}
 
-	return ret, err
+	return rc.get(opt)
 }
 
-// Put ...
+// Put puts the value to the dir in one transaction.
+//
+// A map is merged into the stored map, and a slice is appended to the stored
+// slice. With WithReplace, the dir is replaced by the value exactly.
+// With WithForceKind, a dir of another kind is replaced instead of failing.
+//
+// A value which exceeds Config.MaxTxnOps is committed in staged chunks,
+// readers keep reading the old value until the last chunk is committed.
 func (c *Client) Put(in interface{}, oos ...OpOption) error {
 	opt := parseOption(oos)
-
-	if opt.lock {
-		resp, err := concurrency.NewSTM(
-			c.Client,
-			func(stm concurrency.STM) error {
-				s := newSTM(stm, c)
-				return s.put(in)
-			},
-			concurrency.WithAbortContext(c.ctx),
-			concurrency.WithIsolation(concurrency.RepeatableReads))
-
-		if err != nil {
-			return err
+	return c.update(opt, func(s *STM) error {
+		if opt.replace {
+			s.clear()
 		}
-
-		if opt.tag != "" {
-			rev := resp.Header.Revision
-			return c.mdPutTag(opt.tag, rev)
-		}
-		return nil
-	}
-
-	resp, err := c.put(in)
-	if err != nil {
-		return err
-	}
-
-	if opt.tag != "" {
-		rev := resp.Header.Revision
-		return c.mdPutTag(opt.tag, rev)
-	}
-
-	return nil
+		return s.put(in)
+	})
 }
 
 // Delete ...
 func (c *Client) Delete(oos ...OpOption) error {
 	opt := parseOption(oos)
-
-	var opts []clientv3.Op
-	if dir.Depth(c.rdir) == 1 {
-		opts = []clientv3.Op{
-			clientv3.OpDelete(c.rdir, clientv3.WithPrefix()),
-			clientv3.OpDelete(c.mdir, clientv3.WithPrefix()),
-		}
-	} else {
-
-		pc, err := c.shadowClone("../", "../")
-		if err != nil {
-			return err
-		}
-
-		pkind, err := pc.mdGetKind()
-		if err != nil {
-			return err
-		}
-
-		switch pkind {
-		case Slice, Map:
-			length, err := pc.mdGetLen()
-			if err != nil {
-				return err
-			}
-			if length == 0 {
-				return fmt.Errorf("%s: '%s'", ErrEmptyDir, dir.Join(c.odir, "../"))
-			}
-
-			length -= 1
-
-			ldir := pc.mdLenDir()
-			idir := pc.mdIdxDir(dir.SubD(c.rdir, 1))
-
-			opts = []clientv3.Op{
-				clientv3.OpDelete(c.rdir, clientv3.WithPrefix()),    // delete data
-				clientv3.OpDelete(c.mdir, clientv3.WithPrefix()),    // delete  matedata
-				clientv3.OpDelete(idir),                             // delete idx
-				clientv3.OpPut(ldir, strconv.FormatInt(length, 10)), //
-			}
-		case Scale:
-			return fmt.Errorf("%s 'scale': '%s'", ErrUnsupportedDeletion, dir.Join(c.odir, ".."))
-		case Nil:
-			return fmt.Errorf("%s 'nil': '%s'", ErrUnsupportedDeletion, dir.Join(c.odir, ".."))
-		case Invalid:
-			return fmt.Errorf("%s 'invalid': '%s'", ErrUnsupportedDeletion, dir.Join(c.odir, ".."))
-		default:
-			return fmt.Errorf("%s: '%s'", ErrUnknownType, dir.Join(c.odir, ".."))
-		}
-	}
-
-	resp, err := c.Client.Txn(c.ctx).Then(
-		opts...,
-	).Commit()
-	if err != nil {
-		return err
-	}
-
-	if opt.tag != "" {
-		rev := resp.Header.Revision
-		return c.mdPutTag(opt.tag, rev)
-	}
-
-	return nil
+	return c.update(opt, func(s *STM) error {
+		return s.delete()
+	})
 }
 
 // Do calls function fn on each element of the map/slice.
 func (c *Client) Do(fn func(string, interface{}) bool, oos ...OpOption) error {
 	opt := parseOption(oos)
 
-	var etcdOpts []clientv3.OpOption
-	if opt.tag != "" {
-		rev, err := c.mdGetRev(opt.tag)
-		if err != nil {
-			return err
-		}
-		etcdOpts = []clientv3.OpOption{clientv3.WithRev(rev)}
+	rc, err := c.stableReader(opt)
+	if err != nil {
+		return err
 	}
-	kind, err := c.mdGetKind(etcdOpts...)
+	kind, err := rc.mdGetKind(rc.rev)
 	if err != nil {
 		return err
 	}
@@ -271,22 +213,22 @@ func (c *Client) Do(fn func(string, interface{}) bool, oos ...OpOption) error {
 		return fmt.Errorf("%s '%s': '%s'", ErrUnsupportedDo, kind.String(), c.odir)
 	}
 
-	idxes, err := c.mdGetIdxes(etcdOpts...)
+	idxes, err := rc.mdGetIdxes(rc.rev, kind)
 	if err != nil {
 		return err
 	}
 	for idx, key := range idxes {
-		cs, err := c.shadowClone(strconv.Itoa(idx), key)
+		cs, err := rc.shadowClone(strconv.Itoa(idx), key)
 		if err != nil {
 			return err
 		}
 
-		val, err := cs.Get(oos...)
+		val, err := cs.get(opt)
 		if err != nil {
 			return err
 		}
 
-		ri := key
+		ri := dir.UnescapeKey(key)
 		if kind == Slice {
 			ri = strconv.Itoa(idx)
 		}
@@ -306,8 +248,12 @@ func (c *Client) Do(fn func(string, interface{}) bool, oos ...OpOption) error {
 
 func (c *Client) shadowClone(odir, rdir string) (*Client, error) {
 	sc := &Client{
-		Client: c.Client,
-		ctx:    c.ctx,
+		backend:  c.backend,
+		idxCache: c.idxCache,
+		ctx:      c.ctx,
+		rev:      c.rev,
+
+		typedKeys: c.typedKeys,
 	}
 
 	if dir.IsAbs(odir) && dir.IsAbs(rdir) {
@@ -336,32 +282,79 @@ func (c *Client) shadowClone(odir, rdir string) (*Client, error) {
 	return sc, nil
 }
 
-func (c *Client) put(in interface{}) (*clientv3.PutResponse, error) {
-	v := reflect.ValueOf(in)
-	switch v.Kind() {
-	case reflect.Ptr:
-		return c.put(v.Elem().Interface())
-
-	case reflect.Bool:
-		return c.putBool(v.Bool())
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return c.putInt(v.Int())
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return c.putUint(v.Uint())
-	case reflect.Float32, reflect.Float64:
-		return c.putFloat(v.Float())
-	case reflect.String:
-		return c.putString(v.String())
-	case reflect.Slice, reflect.Array:
-		return c.putSlice(v.Interface())
-	case reflect.Map:
-		return c.putMap(v.Interface())
-	case reflect.Struct:
-		return c.putStruct(v.Interface())
+// atRev returns a copy of the client which reads at revision rev.
+func (c *Client) atRev(rev int64) *Client {
+	rc := *c
+	rc.rev = rev
+	return &rc
+}
 
+// reader returns the client of the read revision of opt, the tag of WithTag
+// or the revision of WithRevision.
+func (c *Client) reader(opt *Option) (*Client, error) {
+	switch {
+	case opt.tag != "":
+		return c.tagged(opt.tag)
+	case opt.rev != 0:
+		return c.atRev(opt.rev), nil
 	default:
-		return nil, fmt.Errorf("%s: '%s'", ErrUnsupportedType, v.Kind().String())
+		return c, nil
+	}
+}
+
+// stableReader returns the reader of opt at its stable revision, so the
+// reads of many dirs see the same revision.
+func (c *Client) stableReader(opt *Option) (*Client, error) {
+	rc, err := c.reader(opt)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := rc.stableRev(rc.rev)
+	if err != nil {
+		return nil, err
+	}
+	return rc.atRev(rev), nil
+}
+
+// get gets the value of the dir at the read revision of the client.
+func (c *Client) get(opt *Option) (interface{}, error) {
+	kvs, md, err := c.readDir(c.rev)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := c.kvParse(kvs, md)
+
+	if opt.eval {
+		return c.eval(ret, opt.evalTags, opt.evalVarFmt, opt.evalVarCheck)
 	}
+
+	return ret, err
+}
+
+// txn applies fn in an STM on the dir until it commits, and updates the
+// cached indexes of the slices it writes.
+func (c *Client) txn(fn func(*STM) error) (*TxnResponse, error) {
+	var last *stmTxn
+	resp, err := runSTM(c.ctx, c.backend, func(stm *stmTxn) error {
+		last = stm
+		stm.stamp = timeKey(c.rdir)
+		s := newSTM(stm, c)
+		if err := s.fence(); err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+		if len(stm.wset) != 0 {
+			stm.Put(timeKey(c.rdir), time.Now().UTC().Format(time.RFC3339Nano))
+		}
+		return nil
+	})
+	// a staged commit spans many revisions
+	if err == nil && len(last.wset) <= Config.MaxTxnOps {
+		c.idxCache.update(last, resp.Revision)
+	}
+	return resp, err
 }
 
 // getKeys ...
@@ -454,7 +447,11 @@ func (c *Client) eval(val interface{}, etags map[string]string,
 			if err != nil {
 				return nil, err
 			}
-			val.(map[string]interface{})[key.String()] = ev
+			evv := reflect.New(v.Type().Elem()).Elem()
+			if ev != nil {
+				evv.Set(reflect.ValueOf(ev))
+			}
+			v.SetMapIndex(key, evv)
 		}
 	default:
 		return val, nil
@@ -462,10 +459,13 @@ func (c *Client) eval(val interface{}, etags map[string]string,
 	return val, nil
 }
 
+// realCurDir returns the real branch of the branch codir of the dir podir,
+// which is the index of an element if the dir is a slice, or else the
+// escaped branch, see escapeBranch.
 func (c *Client) realCurDir(prdir, podir, codir string) (string, error) {
 	iv, err := strconv.ParseInt(codir, 10, 64)
 	if err != nil {
-		return codir, nil
+		return escapeBranch(codir), nil
 	}
 
 	pc, err := c.shadowClone(podir, prdir)
@@ -473,24 +473,23 @@ func (c *Client) realCurDir(prdir, podir, codir string) (string, error) {
 		return "", err
 	}
 
-	kind, err := pc.mdGetKind()
-	if kind != Slice {
-		return codir, nil
-	}
-
-	length, err := pc.mdGetLen()
+	idx, isSlice, err := pc.mdGetSliceIdx(iv)
 	if err != nil {
 		return "", err
 	}
-	if iv >= int64(length) {
-		return "", ErrIndexOutOfRange
+	if !isSlice {
+		return escapeBranch(codir), nil
 	}
+	return idx, nil
+}
 
-	idx, err := pc.mdGetIdxWithOrder(iv)
-	if err != nil {
-		return "", err
+// escapeBranch escapes a branch of a dir as a map key, a branch which is
+// escaped already, such as by dir.EscapeKey, is kept as it is.
+func escapeBranch(branch string) string {
+	if key := dir.UnescapeKey(branch); key != branch && dir.EscapeKey(key) == branch {
+		return branch
 	}
-	return idx, nil
+	return dir.EscapeKey(branch)
 }
 
 func (c *Client) realDir(rdir, odir, newOdir string) (string, error) {
@@ -501,6 +500,7 @@ func (c *Client) realDir(rdir, odir, newOdir string) (string, error) {
 		return "", fmt.Errorf("not matched of real directory and origin directory")
 	}
 
+	// the first-level dirs are not escaped
 	if rdir == "/" {
 		p := dir.ParentD(newOdir, 1)
 		rdir = p
@@ -547,6 +547,35 @@ func (c *Client) realDir(rdir, odir, newOdir string) (string, error) {
 	}
 }
 
+// update applies fn in an STM on the dir, and tags the committed revision.
+func (c *Client) update(opt *Option, fn func(*STM) error) error {
+	if opt.tag != "" {
+		if err := validTagName(opt.tag); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.txn(func(s *STM) error {
+		s.forceKind = opt.forceKind
+		// fail before the write if the tag can not be created
+		if opt.tag != "" && !opt.tagForce {
+			if _, val := c.stmGetTag(s.stm, opt.tag); val != "" {
+				return fmt.Errorf("%s: '%s'", ErrTagExists, opt.tag)
+			}
+		}
+		return fn(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	if opt.tag != "" {
+		rev := resp.Revision
+		return c.mdPutTag(opt, rev)
+	}
+	return nil
+}
+
 // -------------------------------------------------------------------------------------
 // -------------------------------------------------------------------------------------
 // -----------------------------STM's Implementations------------------------------------
@@ -555,14 +584,16 @@ func (c *Client) realDir(rdir, odir, newOdir string) (string, error) {
 
 // STM ...
 type STM struct {
-	stm concurrency.STM
+	stm *stmTxn
+
+	forceKind bool // clear the dir for a value of another kind
 
 	odir string
 	rdir string
 	mdir string
 }
 
-func newSTM(stm concurrency.STM, client *Client) *STM {
+func newSTM(stm *stmTxn, client *Client) *STM {
 	return &STM{
 		stm:  stm,
 		odir: client.odir,
@@ -574,7 +605,8 @@ func newSTM(stm concurrency.STM, client *Client) *STM {
 // ShadowClone
 func (s *STM) shadowClone(odir, rdir string) (*STM, error) {
 	ss := &STM{
-		stm: s.stm,
+		stm:       s.stm,
+		forceKind: s.forceKind,
 	}
 	if dir.IsAbs(odir) && dir.IsAbs(rdir) {
 		ss.odir = odir
@@ -597,9 +629,33 @@ func (s *STM) shadowClone(odir, rdir string) (*STM, error) {
 
 // put ...
 func (s *STM) put(in interface{}) error {
+	switch x := in.(type) {
+	case nil:
+		return s.putNil()
+	case *big.Int:
+		return s.putBigInt(x)
+	case big.Int:
+		return s.putBigInt(&x)
+	case *big.Float:
+		return s.putBigFloat(x)
+	case big.Float:
+		return s.putBigFloat(&x)
+	case json.Number:
+		return s.putNumber(x)
+	}
+	if out, ok, err := marshal(in); ok {
+		if err != nil {
+			return err
+		}
+		return s.put(out)
+	}
+
 	v := reflect.ValueOf(in)
 	switch v.Kind() {
 	case reflect.Ptr:
+		if v.IsNil() {
+			return s.putNil()
+		}
 		return s.put(v.Elem().Interface())
 
 	case reflect.Bool:
@@ -608,16 +664,89 @@ func (s *STM) put(in interface{}) error {
 		return s.putInt(v.Int())
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		return s.putUint(v.Uint())
-	case reflect.Float32, reflect.Float64:
-		return s.putFloat(v.Float())
+	case reflect.Float32:
+		return s.putFloat(v.Float(), 32)
+	case reflect.Float64:
+		return s.putFloat(v.Float(), 64)
 	case reflect.String:
 		return s.putString(v.String())
 	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return s.putBytes(v.Bytes())
+		}
 		return s.putSlice(v.Interface())
 	case reflect.Map:
 		return s.putMap(v.Interface())
+	case reflect.Struct:
+		return s.putStruct(v.Interface())
 
 	default:
 		return fmt.Errorf("%s: '%s'", ErrUnsupportedType, v.Kind().String())
 	}
 }
+
+// putNil puts an explicit nil, which replaces a scale.
+func (s *STM) putNil() error {
+	if kind := s.mdGetKind(); kind != Nil {
+		if kind != Scale && !s.forceKind {
+			return fmt.Errorf("invalid nil type on '%s'", s.odir)
+		}
+		s.clear()
+	}
+	return s.putKind(Nil)
+}
+
+// putKind puts the kind of a nil, map or slice, and the key of the dir, which
+// keeps the dir on a read even if it is empty.
+func (s *STM) putKind(k Kind) error {
+	s.stm.Put(s.rdir, "")
+	return s.mdPutKind(k)
+}
+
+// delete deletes the dir, and its index in the parent map/slice.
+func (s *STM) delete() error {
+	if dir.Depth(s.rdir) > 1 {
+		ps, err := s.shadowClone("../", "../")
+		if err != nil {
+			return err
+		}
+
+		switch ps.mdGetKind() {
+		case Slice, Map:
+			length, err := ps.mdGetLen()
+			if err != nil {
+				return err
+			}
+			if length == 0 {
+				return fmt.Errorf("%s: '%s'", ErrEmptyDir, ps.odir)
+			}
+
+			idx := dir.SubD(s.rdir, 1)
+			if ps.mdIdxExists(idx) {
+				ps.mdDelIdx(idx)
+				ps.mdPutLen(length - 1)
+			}
+		case Scale:
+			return fmt.Errorf("%s 'scale': '%s'", ErrUnsupportedDeletion, ps.odir)
+		case Nil:
+			return fmt.Errorf("%s 'nil': '%s'", ErrUnsupportedDeletion, ps.odir)
+		default:
+			return fmt.Errorf("%s: '%s'", ErrUnknownType, ps.odir)
+		}
+	}
+
+	s.stm.DelPrefix(s.rdir)
+	s.stm.DelPrefix(s.mdir)
+	return nil
+}
+
+// clear deletes the data and metadata of the dir, but keeps the tags of the
+// old layout.
+func (s *STM) clear() {
+	var keep []string
+	if dir.Depth(s.rdir) == 1 {
+		keep = append(keep, legacyTagDir(s.rdir))
+	}
+	s.stm.DelPrefix(s.rdir)
+	s.stm.DelPrefix(s.mdir, keep...)
+}
@@ -5,41 +5,29 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 
-	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/helloyi/setcd/dir"
 )
 
 // GetSlice ...
 func (c *Client) GetSlice(oos ...OpOption) ([]interface{}, error) {
 	opt := parseOption(oos)
 
-	kind, err := c.mdGetKind()
+	rc, err := c.reader(opt)
 	if err != nil {
 		return nil, err
 	}
-	if kind != Slice {
-		return nil, errors.New("not a slice type on " + c.odir)
-	}
-
-	etcdOpts := []clientv3.OpOption{
-		clientv3.WithPrefix(),
-		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
-	}
-	if opt.tag != "" {
-		rev, err := c.mdGetRev(opt.tag)
-		if err != nil {
-			return nil, err
-		}
-		etcdOpts = append(etcdOpts, clientv3.WithRev(rev))
-	}
-
-	resp, err := c.Client.Get(c.ctx, c.rdir, etcdOpts...)
+	kvs, md, err := rc.readDir(rc.rev)
 	if err != nil {
 		return nil, err
 	}
 
-	ret, err := c.kvParseSlice(resp.Kvs)
+	if c.kvParseKind(kvs, md) != Slice {
+		return nil, errors.New("not a slice type on " + c.odir)
+	}
+
+	ret, err := c.kvParseSlice(kvs, md)
 
 	if opt.eval {
 		return c.evalSlice(ret, opt.evalTags, opt.evalVarFmt, opt.evalVarCheck)
@@ -65,21 +53,12 @@ func (c *Client) evalSlice(val []interface{}, etags map[string]string,
 
 func (c *Client) PutSlice(in []interface{}, oos ...OpOption) error {
 	opt := parseOption(oos)
-	resp, err := concurrency.NewSTM(c.Client, func(stm concurrency.STM) error {
-		s := newSTM(stm, c)
+	return c.update(opt, func(s *STM) error {
+		if opt.replace {
+			s.clear()
+		}
 		return s.putSlice(in)
-	}, concurrency.WithAbortContext(c.ctx))
-
-	if err != nil {
-		return err
-	}
-
-	if opt.tag != "" {
-		rev := resp.Header.Revision
-		return c.mdPutTag(opt.tag, rev)
-	}
-
-	return nil
+	})
 }
 
 // putSlice ...
@@ -91,7 +70,10 @@ func (s *STM) putSlice(sin interface{}) error {
 	// check type when update
 	kind := s.mdGetKind()
 	if kind != Nil && kind != Slice {
-		return fmt.Errorf("invalid slice type on '%s', but is '%s'", s.odir, kind)
+		if !s.forceKind {
+			return fmt.Errorf("invalid slice type on '%s', but is '%s'", s.odir, kind)
+		}
+		s.clear()
 	}
 
 	oldLen, err := s.mdGetLen()
@@ -120,7 +102,7 @@ func (s *STM) putSlice(sin interface{}) error {
 			return err
 		}
 	}
-	s.mdPutKind(Slice)
+	s.putKind(Slice)
 	s.mdPutLen(newLen)
 	s.mdPutLastID(id)
 	return nil
@@ -130,16 +112,12 @@ func (s *STM) putSlice(sin interface{}) error {
 func (c *Client) DoSlice(fn func(int, interface{}) bool, oos ...OpOption) error {
 	opt := parseOption(oos)
 
-	var etcdOpts []clientv3.OpOption
-	if opt.tag != "" {
-		rev, err := c.mdGetRev(opt.tag)
-		if err != nil {
-			return err
-		}
-		etcdOpts = []clientv3.OpOption{clientv3.WithRev(rev)}
+	rc, err := c.stableReader(opt)
+	if err != nil {
+		return err
 	}
 
-	kind, err := c.mdGetKind(etcdOpts...)
+	kind, err := rc.mdGetKind(rc.rev)
 	if err != nil {
 		return err
 	}
@@ -148,18 +126,18 @@ func (c *Client) DoSlice(fn func(int, interface{}) bool, oos ...OpOption) error
 		return fmt.Errorf("not slice type on '%s'", c.odir)
 	}
 
-	idxes, err := c.mdGetIdxes(etcdOpts...)
+	idxes, err := rc.mdGetIdxes(rc.rev, Slice)
 	if err != nil {
 		return err
 	}
 
 	for idx, key := range idxes {
-		cs, err := c.shadowClone(strconv.Itoa(idx), key)
+		cs, err := rc.shadowClone(strconv.Itoa(idx), key)
 		if err != nil {
 			return err
 		}
 
-		val, err := cs.Get(oos...)
+		val, err := cs.get(opt)
 		if err != nil {
 			return err
 		}
@@ -171,58 +149,234 @@ func (c *Client) DoSlice(fn func(int, interface{}) bool, oos ...OpOption) error
 	return nil
 }
 
-// putSlice ...
-func (c *Client) putSlice(sin interface{}) (*clientv3.PutResponse, error) {
-	v := reflect.ValueOf(sin)
-	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
-		return nil, fmt.Errorf("required slice/array type of Client.putSlice, but is '%s'", v.Kind())
-	}
-	// check type when update
-	kind, err := c.mdGetKind()
-	if err != nil {
-		return nil, err
+//
+// Edit
+//
+
+// InsertAt inserts v before the i-th element of the slice, i may be the
+// length of the slice to append v.
+func (c *Client) InsertAt(i int, v interface{}, oos ...OpOption) error {
+	return c.update(parseOption(oos), func(s *STM) error {
+		return s.splice(i, 0, []interface{}{v})
+	})
+}
+
+// RemoveAt removes the i-th element of the slice.
+func (c *Client) RemoveAt(i int, oos ...OpOption) error {
+	return c.update(parseOption(oos), func(s *STM) error {
+		return s.splice(i, 1, nil)
+	})
+}
+
+// Move moves the from-th element of the slice to be the to-th element.
+func (c *Client) Move(from, to int, oos ...OpOption) error {
+	return c.update(parseOption(oos), func(s *STM) error {
+		return s.move(from, to)
+	})
+}
+
+// Splice removes n elements from the i-th element of the slice, and inserts
+// vs in their place.
+func (c *Client) Splice(i, n int, vs ...interface{}) error {
+	return c.SpliceWith(i, n, vs)
+}
+
+// SpliceWith is Splice with the options of an update, such as WithTag.
+func (c *Client) SpliceWith(i, n int, vs []interface{}, oos ...OpOption) error {
+	return c.update(parseOption(oos), func(s *STM) error {
+		return s.splice(i, n, vs)
+	})
+}
+
+// mdGetIdxes returns the indexes of the slice in element order, and guards
+// them against the other writers.
+func (s *STM) mdGetIdxes() []string {
+	idxesDir := dir.Join(s.mdir, Config.MD.IdxesSubDir)
+	kvs := s.stm.Range(idxesDir, prefixEnd(idxesDir))
+	s.stm.Guard(idxesDir)
+
+	idxes := make([]string, len(kvs))
+	for i, kv := range kvs {
+		idx := strings.TrimPrefix(kv.Key, idxesDir)
+		idxes[i] = strings.Trim(idx, "/")
 	}
+	s.stm.idxes[s.mdir] = idxes
+	return idxes
+}
+
+// splice removes n elements from the i-th element, and inserts vs.
+func (s *STM) splice(i, n int, vs []interface{}) error {
+	kind := s.mdGetKind()
 	if kind != Nil && kind != Slice {
-		return nil, fmt.Errorf("invalid slice type on '%s', but is '%s'", c.odir, kind)
+		return fmt.Errorf("invalid slice type on '%s', but is '%s'", s.odir, kind)
+	}
+
+	idxes := s.mdGetIdxes()
+	if i < 0 || n < 0 || i+n > len(idxes) {
+		return fmt.Errorf("%s: [%d:%d] on '%s' of length %d", ErrIndexOutOfRange, i, i+n, s.odir, len(idxes))
+	}
+
+	for _, idx := range idxes[i : i+n] {
+		es, err := s.shadowClone(idx, idx)
+		if err != nil {
+			return err
+		}
+		es.clear()
+		s.mdDelIdx(idx)
 	}
 
-	oldLen, err := c.mdGetLen()
+	lo, hi := "", ""
+	if i > 0 {
+		lo = idxes[i-1]
+	}
+	if i+n < len(idxes) {
+		hi = idxes[i+n]
+	}
+	keys, err := s.idxesBetween(lo, hi, len(vs))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for k, idx := range keys {
+		s.mdPutIdx(idx)
+		es, err := s.shadowClone(strconv.Itoa(i+k), idx)
+		if err != nil {
+			return err
+		}
+		if err := es.put(vs[k]); err != nil {
+			return err
+		}
+	}
+
+	s.putKind(Slice)
+	s.mdPutLen(int64(len(idxes) - n + len(vs)))
+	return nil
+}
+
+// move moves the from-th element to be the to-th element.
+//
+// The data and metadata of the element are renamed to the new index, which
+// is ordered between its new neighbors.
+func (s *STM) move(from, to int) error {
+	if kind := s.mdGetKind(); kind != Slice {
+		return fmt.Errorf("invalid slice type on '%s', but is '%s'", s.odir, kind)
+	}
+
+	idxes := s.mdGetIdxes()
+	if from < 0 || from >= len(idxes) || to < 0 || to >= len(idxes) {
+		return fmt.Errorf("%s: move %d to %d on '%s' of length %d", ErrIndexOutOfRange, from, to, s.odir, len(idxes))
+	}
+	if from == to {
+		return nil
+	}
+
+	old := idxes[from]
+	rest := append(append([]string{}, idxes[:from]...), idxes[from+1:]...)
+	lo, hi := "", ""
+	if to > 0 {
+		lo = rest[to-1]
 	}
-	id, err := c.mdGetLastID()
+	if to < len(rest) {
+		hi = rest[to]
+	}
+	keys, err := s.idxesBetween(lo, hi, 1)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	inLen := v.Len()
-	newLen := oldLen + int64(inLen)
+	idx := keys[0]
 
-	for i := 0; i < inLen; i++ {
-		// put idx table
-		id += 1
-		idx := fmt.Sprintf("%019d", id)
-		_, err := c.mdPutIdx(idx)
-		if err != nil {
-			return nil, err
+	for _, prefix := range []string{s.rdir, s.mdir} {
+		oldDir := dir.Join(prefix, old)
+		newDir := dir.Join(prefix, idx)
+		for _, kv := range s.stm.Range(oldDir, prefixEnd(oldDir)) {
+			s.stm.Put(newDir+strings.TrimPrefix(kv.Key, oldDir), kv.Value)
 		}
+		s.stm.DelPrefix(oldDir)
+	}
+	s.mdDelIdx(old)
+	s.mdPutIdx(idx)
+	// the order is changed, see idxCache
+	s.mdPutLen(int64(len(idxes)))
+	return nil
+}
 
-		fmt.Println("Client ", i, idx)
-		elem := v.Index(i)
-		ds, err := c.shadowClone(strconv.FormatInt(int64(i)+oldLen, 10), idx)
+// idxesBetween returns n new indexes ordered between the indexes lo and hi,
+// "" is the begin or end of the slice.
+//
+// Indexes after the last one are allocated from '__lastID__', the others are
+// digit strings which sort between their neighbors.
+func (s *STM) idxesBetween(lo, hi string, n int) ([]string, error) {
+	idxes := make([]string, 0, n)
+	if n == 0 {
+		return idxes, nil
+	}
+	if hi == "" {
+		id, err := s.mdGetLastID()
 		if err != nil {
 			return nil, err
 		}
-		if _, err := ds.put(elem.Interface()); err != nil {
-			return nil, err
+		for ; len(idxes) < n; id++ {
+			idxes = append(idxes, fmt.Sprintf("%019d", id+1))
 		}
+		s.mdPutLastID(id)
+		return idxes, nil
 	}
-	_, err = c.mdPutKind(Slice)
-	if err != nil {
-		return nil, err
+	return appendMidpoints(idxes, lo, hi, n), nil
+}
+
+// appendMidpoints appends n digit strings which sort between lo and hi,
+// the middle one first splits the range to keep the strings short.
+func appendMidpoints(dst []string, lo, hi string, n int) []string {
+	if n == 0 {
+		return dst
 	}
-	_, err = c.mdPutLen(newLen)
-	if err != nil {
-		return nil, err
+	mid := midpoint(lo, hi)
+	left := (n - 1) / 2
+	dst = appendMidpoints(dst, lo, mid, left)
+	dst = append(dst, mid)
+	return appendMidpoints(dst, mid, hi, n-1-left)
+}
+
+// midpoint returns a digit string which sorts between lo and hi, "" of hi
+// is the end. The result never ends with '0', or nothing sorts before it
+// with the same prefix.
+func midpoint(lo, hi string) string {
+	digit := func(s string, i int, pad byte) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return pad
+	}
+
+	// common prefix, lo is padded with '0'
+	if hi != "" {
+		n := 0
+		for n < len(hi) && digit(lo, n, '0') == hi[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(lo) {
+				rest = lo[n:]
+			}
+			return hi[:n] + midpoint(rest, hi[n:])
+		}
+	}
+
+	dl := int(digit(lo, 0, '0') - '0')
+	dh := 10
+	if hi != "" {
+		dh = int(hi[0] - '0')
+	}
+	if dh-dl > 1 {
+		return string(byte('0' + (dl+dh)/2))
+	}
+	// consecutive digits
+	if len(hi) > 1 {
+		return hi[:1]
+	}
+	rest := ""
+	if len(lo) > 1 {
+		rest = lo[1:]
 	}
-	return c.mdPutLastID(id)
+	return string(byte('0'+dl)) + midpoint(rest, "")
 }
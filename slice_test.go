@@ -0,0 +1,187 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/helloyi/setcd"
+)
+
+// countingBackend counts the round trips and the key-value pairs read.
+type countingBackend struct {
+	setcd.Backend
+	calls int64
+	kvs   int64
+}
+
+func (b *countingBackend) Do(ctx context.Context, op setcd.Op) (*setcd.Response, error) {
+	atomic.AddInt64(&b.calls, 1)
+	resp, err := b.Backend.Do(ctx, op)
+	if err == nil {
+		atomic.AddInt64(&b.kvs, int64(len(resp.Kvs)))
+	}
+	return resp, err
+}
+
+func (b *countingBackend) Txn(ctx context.Context, cmps []setcd.Cmp, thenOps, elseOps []setcd.Op) (*setcd.TxnResponse, error) {
+	atomic.AddInt64(&b.calls, 1)
+	resp, err := b.Backend.Txn(ctx, cmps, thenOps, elseOps)
+	if err == nil {
+		for _, r := range resp.Responses {
+			atomic.AddInt64(&b.kvs, int64(len(r.Kvs)))
+		}
+	}
+	return resp, err
+}
+
+var _ = Describe("Slice", func() {
+	var cli *setcd.Client
+
+	BeforeEach(func() {
+		var err error
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), context.Background(), "/Slice")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put([]interface{}{"a", "b", "c"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	expectSlice := func(vs ...interface{}) {
+		res, err := cli.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(vs))
+	}
+
+	Specify("edit", func() {
+		Expect(cli.InsertAt(0, "x")).To(Succeed())
+		expectSlice("x", "a", "b", "c")
+		Expect(cli.InsertAt(2, "y")).To(Succeed())
+		expectSlice("x", "a", "y", "b", "c")
+		Expect(cli.InsertAt(5, "z")).To(Succeed())
+		expectSlice("x", "a", "y", "b", "c", "z")
+
+		Expect(cli.RemoveAt(1)).To(Succeed())
+		expectSlice("x", "y", "b", "c", "z")
+
+		Expect(cli.Move(0, 4)).To(Succeed())
+		expectSlice("y", "b", "c", "z", "x")
+		Expect(cli.Move(3, 0)).To(Succeed())
+		expectSlice("z", "y", "b", "c", "x")
+
+		Expect(cli.Splice(1, 2, "p", "q", "r")).To(Succeed())
+		expectSlice("z", "p", "q", "r", "c", "x")
+		Expect(cli.SpliceWith(0, 0, nil, setcd.WithTag("spliced"))).To(Succeed())
+		Expect(cli.Get(setcd.WithTag("spliced"))).To(Equal([]interface{}{"z", "p", "q", "r", "c", "x"}))
+
+		Expect(cli.RemoveAt(6)).NotTo(Succeed())
+		Expect(cli.Move(0, 6)).NotTo(Succeed())
+
+		// elements are addressed by position
+		ec, err := cli.ShadowClone("2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ec.Get()).To(Equal("q"))
+	})
+
+	Specify("nested elements", func() {
+		Expect(cli.InsertAt(1, map[string]interface{}{"k": []interface{}{"m", "n"}})).To(Succeed())
+		Expect(cli.Move(1, 3)).To(Succeed())
+		expectSlice("a", "b", "c", map[string]interface{}{"k": []interface{}{"m", "n"}})
+
+		ec, err := cli.ShadowClone("3/k/1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ec.Get()).To(Equal("n"))
+	})
+
+	Specify("resolve a position", func() {
+		backend := &countingBackend{Backend: setcd.NewMemoryBackend()}
+		lc, err := setcd.NewWithBackend(backend, context.Background(), "/List")
+		Expect(err).NotTo(HaveOccurred())
+		defer lc.Close()
+
+		hosts := make([]interface{}, 500)
+		for i := range hosts {
+			hosts[i] = "host" + strconv.Itoa(i)
+		}
+		Expect(lc.Put(hosts)).To(Succeed())
+
+		resolve := func(c *setcd.Client, i int) (interface{}, int64) {
+			n := atomic.LoadInt64(&backend.kvs)
+			ec, err := c.ShadowClone(strconv.Itoa(i))
+			Expect(err).NotTo(HaveOccurred())
+			v, err := ec.Get()
+			Expect(err).NotTo(HaveOccurred())
+			return v, atomic.LoadInt64(&backend.kvs) - n
+		}
+
+		v, _ := resolve(lc, 499)
+		Expect(v).To(Equal("host499"))
+
+		// the indexes are cached until the slice is changed
+		v, n := resolve(lc, 450)
+		Expect(v).To(Equal("host450"))
+		Expect(n).To(BeNumerically("<", 10))
+
+		// and updated by the writes of the client
+		Expect(lc.Move(0, 499)).To(Succeed())
+		v, n = resolve(lc, 450)
+		Expect(v).To(Equal("host451"))
+		Expect(n).To(BeNumerically("<", 10))
+		v, _ = resolve(lc, 499)
+		Expect(v).To(Equal("host0"))
+
+		Expect(lc.InsertAt(10, "new")).To(Succeed())
+		Expect(lc.RemoveAt(0)).To(Succeed())
+		v, n = resolve(lc, 9)
+		Expect(v).To(Equal("new"))
+		Expect(n).To(BeNumerically("<", 10))
+		v, _ = resolve(lc, 499)
+		Expect(v).To(Equal("host0"))
+
+		// a cold client reads the indexes up to the element
+		oc, err := setcd.NewWithBackend(backend, context.Background(), "/List")
+		Expect(err).NotTo(HaveOccurred())
+		v, n = resolve(oc, 3)
+		Expect(v).To(Equal("host5"))
+		Expect(n).To(BeNumerically("<", 10))
+
+		// so does a client after the writes of another
+		Expect(oc.InsertAt(0, "other")).To(Succeed())
+		v, n = resolve(lc, 3)
+		Expect(v).To(Equal("host4"))
+		Expect(n).To(BeNumerically("<", 10))
+
+		_, err = lc.ShadowClone("501")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("splice many", func() {
+		vs := make([]interface{}, 100)
+		for i := range vs {
+			vs[i] = "v" + strconv.Itoa(i)
+		}
+		Expect(cli.Put(vs, setcd.WithReplace())).To(Succeed())
+
+		n := setcd.Config.MaxTxnOps/2 + 16
+		Expect(cli.Splice(0, n, "x")).To(Succeed())
+		expectSlice(append([]interface{}{"x"}, vs[n:]...)...)
+	})
+
+	Specify("repeated inserts", func() {
+		want := []interface{}{"a", "b", "c"}
+		for _, v := range []string{"d", "e", "g", "h", "i", "j", "k", "l"} {
+			Expect(cli.InsertAt(1, v)).To(Succeed())
+			Expect(cli.InsertAt(0, v)).To(Succeed())
+			want = append([]interface{}{v, want[0], v}, want[1:]...)
+		}
+		expectSlice(want...)
+	})
+})
@@ -0,0 +1,69 @@
+package setcd
+
+// Snapshot is a read-only view of the dirs at one revision.
+//
+// All reads of a snapshot and its clones are served at the same revision,
+// so they never observe a torn state between them. A read fails with
+// ErrCompacted once the revision has been compacted. WithTag and WithRevision
+// override the revision of a read.
+type Snapshot struct {
+	c *Client
+}
+
+// Snapshot returns a snapshot of the current revision.
+func (c *Client) Snapshot() (*Snapshot, error) {
+	rev, err := c.backend.Rev(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{c: c.atRev(rev)}, nil
+}
+
+// View calls fn with a snapshot of the current revision.
+func (c *Client) View(fn func(*Snapshot) error) error {
+	s, err := c.Snapshot()
+	if err != nil {
+		return err
+	}
+	return fn(s)
+}
+
+// Revision returns the revision of the snapshot.
+func (s *Snapshot) Revision() int64 {
+	return s.c.rev
+}
+
+// ShadowClone returns the snapshot of another dir at the same revision, a
+// relative directory is joined to the dir of s.
+func (s *Snapshot) ShadowClone(directory string) (*Snapshot, error) {
+	c, err := s.c.ShadowClone(directory)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{c: c}, nil
+}
+
+// Get ...
+func (s *Snapshot) Get(oos ...OpOption) (interface{}, error) {
+	return s.c.Get(oos...)
+}
+
+// GetMap ...
+func (s *Snapshot) GetMap(oos ...OpOption) (map[string]interface{}, error) {
+	return s.c.GetMap(oos...)
+}
+
+// GetSlice ...
+func (s *Snapshot) GetSlice(oos ...OpOption) ([]interface{}, error) {
+	return s.c.GetSlice(oos...)
+}
+
+// GetStructVar ...
+func (s *Snapshot) GetStructVar(out interface{}, oos ...OpOption) error {
+	return s.c.GetStructVar(out, oos...)
+}
+
+// Do calls function fn on each element of the map/slice.
+func (s *Snapshot) Do(fn func(string, interface{}) bool, oos ...OpOption) error {
+	return s.c.Do(fn, oos...)
+}
@@ -0,0 +1,68 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Snapshot", func() {
+	var cli *setcd.Client
+
+	BeforeEach(func() {
+		var err error
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), context.Background(), "/app")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(map[string]interface{}{
+			"db":       map[string]interface{}{"host": "db1"},
+			"cache":    map[string]interface{}{"host": "cache1"},
+			"features": []interface{}{"login"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("reads at one revision", func() {
+		err := cli.View(func(s *setcd.Snapshot) error {
+			// changes after the snapshot are not seen
+			err := cli.Put(map[string]interface{}{
+				"db":    map[string]interface{}{"host": "db2"},
+				"cache": map[string]interface{}{"host": "cache2"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			fc, err := cli.ShadowClone("features")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fc.InsertAt(0, "search")).To(Succeed())
+
+			type server struct{ Host string }
+			var db server
+			dbs, err := s.ShadowClone("db")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbs.GetStructVar(&db)).To(Succeed())
+			Expect(db).To(Equal(server{Host: "db1"}))
+
+			cs, err := s.ShadowClone("/app/cache/host")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cs.Get()).To(Equal("cache1"))
+
+			fs, err := s.ShadowClone("features/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fs.Get()).To(Equal("login"))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		fc, err := cli.ShadowClone("features")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fc.GetSlice()).To(Equal([]interface{}{"search", "login"}))
+	})
+})
@@ -0,0 +1,488 @@
+package setcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// stmTxn is a software transactional memory on a Backend.
+//
+// All reads are served at the revision of the first read, and a read key is
+// cached for the txn (repeatable reads). Writes are buffered and committed in
+// one backend Txn, guarded by the mod revisions of all read keys.
+//
+// Writes which exceed Config.MaxTxnOps are committed in staged chunks behind
+// a pending marker, see commitStaged.
+type stmTxn struct {
+	ctx     context.Context
+	backend Backend
+
+	rev  int64                // read revision, 0 until the first read
+	rset map[string]*KeyValue // read keys, nil value is a missing key
+	wset map[string]*stmWrite // buffered writes
+
+	conds  []Cmp    // extra conditions of the commit
+	scopes []string // prefixes which guard the read keys in them when there are too many
+	marker string   // pending marker key of staged commits
+	stamp  string   // key put by the last chunk of a staged commit only
+
+	idxes map[string][]string // indexes of the slices read by the txn, by metadata dir
+}
+
+// stmWrite is a buffered write, a nil value is a deletion.
+type stmWrite struct {
+	val *string
+}
+
+// runSTM applies apply in a stmTxn until the txn commits. An apply which
+// returns a stmWait is applied again after a backoff.
+func runSTM(ctx context.Context, backend Backend, apply func(*stmTxn) error) (*TxnResponse, error) {
+	backoff := minBackoff
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		t := &stmTxn{
+			ctx:     ctx,
+			backend: backend,
+			rset:    make(map[string]*KeyValue),
+			wset:    make(map[string]*stmWrite),
+			idxes:   make(map[string][]string),
+		}
+		err := t.apply(apply)
+		if w, ok := err.(stmWait); ok {
+			select {
+			case <-ctx.Done():
+				return nil, w.err
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		if err == errRetrySTM {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.commit()
+		if err != nil {
+			return nil, err
+		}
+		if resp.Succeeded {
+			return resp, nil
+		}
+	}
+}
+
+// apply calls fn, a backend error of reads aborts fn.
+func (t *stmTxn) apply(fn func(*stmTxn) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(stmError)
+			if !ok {
+				panic(r)
+			}
+			err = e.err
+		}
+	}()
+	return fn(t)
+}
+
+// Get returns the value of key, or "" if key does not exist.
+func (t *stmTxn) Get(key string) string {
+	if w, ok := t.wset[key]; ok {
+		if w.val == nil {
+			return ""
+		}
+		return *w.val
+	}
+	if kv := t.read(key); kv != nil {
+		return kv.Value
+	}
+	return ""
+}
+
+// Rev returns the mod revision of key, or 0 if key does not exist.
+func (t *stmTxn) Rev(key string) int64 {
+	if w, ok := t.wset[key]; ok && w.val == nil {
+		return 0
+	}
+	if kv := t.read(key); kv != nil {
+		return kv.ModRevision
+	}
+	return 0
+}
+
+// Put puts val to key.
+func (t *stmTxn) Put(key, val string) {
+	t.wset[key] = &stmWrite{val: &val}
+}
+
+// Del deletes key.
+func (t *stmTxn) Del(key string) {
+	t.wset[key] = &stmWrite{}
+}
+
+// DelPrefix deletes the keys with prefix but the keys with a prefix of keep,
+// and guards the range against the keys written after the read revision.
+func (t *stmTxn) DelPrefix(prefix string, keep ...string) {
+	kept := func(key string) bool {
+		for _, k := range keep {
+			if strings.HasPrefix(key, k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for key := range t.wset {
+		if strings.HasPrefix(key, prefix) && !kept(key) {
+			delete(t.wset, key)
+		}
+	}
+
+	for _, kv := range t.Range(prefix, prefixEnd(prefix)) {
+		if !kept(kv.Key) {
+			t.Del(kv.Key)
+		}
+	}
+	t.Guard(prefix)
+}
+
+// Guard guards the keys with prefix against the writes after the read
+// revision. A deletion in the range is not detected.
+func (t *stmTxn) Guard(prefix string) {
+	if t.rev == 0 {
+		t.Range(prefix, prefixEnd(prefix))
+	}
+	t.conds = append(t.conds, Cmp{
+		Key:    prefix,
+		End:    prefixEnd(prefix),
+		Target: CmpModRevision,
+		Result: "<",
+		Rev:    t.rev + 1,
+	})
+}
+
+// Range returns the key-value pairs in [key, end) at the read revision,
+// without the buffered writes. The range is not guarded by the commit.
+func (t *stmTxn) Range(key, end string) []*KeyValue {
+	resp, err := t.backend.Do(t.ctx, Op{Type: OpGet, Key: key, End: end, Rev: t.rev})
+	if err != nil {
+		panic(stmError{err})
+	}
+	if t.rev == 0 {
+		t.rev = resp.Revision
+	}
+	return resp.Kvs
+}
+
+// read reads key at the read revision, caches it in the read set.
+func (t *stmTxn) read(key string) *KeyValue {
+	if kv, ok := t.rset[key]; ok {
+		return kv
+	}
+
+	resp, err := t.backend.Do(t.ctx, opGet(key, t.rev))
+	if err != nil {
+		panic(stmError{err})
+	}
+	if t.rev == 0 {
+		t.rev = resp.Revision
+	}
+
+	var kv *KeyValue
+	if len(resp.Kvs) != 0 {
+		kv = resp.Kvs[0]
+	}
+	t.rset[key] = kv
+	return kv
+}
+
+// commit commits the buffered writes if no read key has been changed.
+func (t *stmTxn) commit() (*TxnResponse, error) {
+	cmps := t.cmps()
+	ops := make([]Op, 0, len(t.wset))
+	var stamp []Op
+	for key, w := range t.wset {
+		op := opDelete(key)
+		if w.val != nil {
+			op = opPut(key, *w.val)
+		}
+		if key == t.stamp {
+			stamp = append(stamp, op)
+		} else {
+			ops = append(ops, op)
+		}
+	}
+	ops = append(ops, stamp...)
+
+	if len(cmps) > Config.MaxTxnOps {
+		return nil, fmt.Errorf("%s: %d conditions", ErrTooManyOps, len(cmps))
+	}
+	if len(ops) > Config.MaxTxnOps {
+		return t.commitStaged(cmps, ops)
+	}
+	return t.backend.Txn(t.ctx, cmps, ops, nil)
+}
+
+// cmps returns the conditions of the commit.
+//
+// Each read key must keep its mod revision. When there are too many read
+// keys or conditions, the keys and guarded prefixes in a scope are guarded by
+// the mod revision of the whole scope instead.
+func (t *stmTxn) cmps() []Cmp {
+	collapse := len(t.rset)+len(t.conds) > Config.MaxTxnOps
+
+	var cmps []Cmp
+	for _, cmp := range t.conds {
+		if collapse && t.isGuard(cmp) && t.inScope(cmp.Key) {
+			continue
+		}
+		cmps = append(cmps, cmp)
+	}
+
+	for key, kv := range t.rset {
+		if collapse && t.inScope(key) {
+			continue
+		}
+		var rev int64
+		if kv != nil {
+			rev = kv.ModRevision
+		}
+		cmps = append(cmps, cmpModRev(key, "=", rev))
+	}
+
+	if collapse {
+		for _, scope := range t.scopes {
+			cmps = append(cmps, Cmp{
+				Key:    scope,
+				End:    prefixEnd(scope),
+				Target: CmpModRevision,
+				Result: "<",
+				Rev:    t.rev + 1,
+			})
+		}
+	}
+	return cmps
+}
+
+// isGuard reports whether cmp is a condition of Guard, which is covered by
+// the guard of a scope with its prefix.
+func (t *stmTxn) isGuard(cmp Cmp) bool {
+	return cmp.Target == CmpModRevision && cmp.Result == "<" && cmp.Rev == t.rev+1 &&
+		cmp.End == prefixEnd(cmp.Key)
+}
+
+func (t *stmTxn) inScope(key string) bool {
+	for _, scope := range t.scopes {
+		if strings.HasPrefix(key, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// commitStaged commits ops in chunks.
+//
+// The first chunk is guarded by cmps and puts the pending marker, which
+// records the keys of ops, the last chunk deletes it. Readers which find a
+// pending marker read at the revision before it, so they never see a partial
+// write. A chunk which fails aborts the write, the written keys are restored
+// while the marker is still owned.
+func (t *stmTxn) commitStaged(cmps []Cmp, ops []Op) (*TxnResponse, error) {
+	if t.marker == "" {
+		return nil, fmt.Errorf("%s: %d operations", ErrTooManyOps, len(ops))
+	}
+
+	token, err := pendingRecord{Start: time.Now().UnixNano(), Keys: opKeys(ops)}.encode()
+	if err != nil {
+		return nil, err
+	}
+	size := Config.MaxTxnOps - 1 // room for the marker
+
+	chunk := append([]Op{opPut(t.marker, token)}, ops[:size]...)
+	resp, err := t.backend.Txn(t.ctx, cmps, chunk, nil)
+	if err != nil || !resp.Succeeded {
+		return resp, err
+	}
+
+	base := resp.Revision - 1
+	written := opKeys(ops[:size])
+	abort := func(err error) error {
+		if aerr := rollbackStaged(t.ctx, t.backend, t.marker, token, base, written); aerr != nil {
+			return fmt.Errorf("%s, abort: %s", err, aerr)
+		}
+		return err
+	}
+
+	owned := []Cmp{cmpValue(t.marker, "=", token)}
+	for ops = ops[size:]; len(ops) > size; ops = ops[size:] {
+		resp, err := t.backend.Txn(t.ctx, owned, ops[:size], nil)
+		if err != nil {
+			return nil, abort(err)
+		}
+		if !resp.Succeeded {
+			return nil, fmt.Errorf("%s: '%s'", ErrLostPending, t.marker)
+		}
+		written = append(written, opKeys(ops[:size])...)
+	}
+
+	chunk = append(ops, opDelete(t.marker))
+	resp, err = t.backend.Txn(t.ctx, owned, chunk, nil)
+	if err != nil {
+		return nil, abort(err)
+	}
+	if !resp.Succeeded {
+		return nil, fmt.Errorf("%s: '%s'", ErrLostPending, t.marker)
+	}
+	return resp, nil
+}
+
+// pendingRecord is the value of a pending marker.
+type pendingRecord struct {
+	Start int64    `json:"start"` // unix nanoseconds when the staged write starts
+	Keys  []string `json:"keys"`  // keys of the staged write
+}
+
+func (r pendingRecord) encode() (string, error) {
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+// stale reports whether the staged write has been started more than
+// Config.PendingTimeout ago.
+func (r pendingRecord) stale() bool {
+	return time.Since(time.Unix(0, r.Start)) > Config.PendingTimeout
+}
+
+// takeOverStaged takes over the pending marker kv if it is stale, and rolls
+// back its staged write. It reports whether the marker has been removed.
+func takeOverStaged(ctx context.Context, backend Backend, kv *KeyValue) (bool, error) {
+	var rec pendingRecord
+	if err := json.Unmarshal([]byte(kv.Value), &rec); err != nil {
+		return false, fmt.Errorf("%s: '%s': %s", ErrPendingWrite, kv.Key, err)
+	}
+	if !rec.stale() {
+		return false, nil
+	}
+
+	rec.Start = time.Now().UnixNano()
+	token, err := rec.encode()
+	if err != nil {
+		return false, err
+	}
+	resp, err := backend.Txn(ctx, []Cmp{cmpValue(kv.Key, "=", kv.Value)}, []Op{opPut(kv.Key, token)}, nil)
+	if err != nil || !resp.Succeeded { // taken over by another writer
+		return false, err
+	}
+
+	if err := rollbackStaged(ctx, backend, kv.Key, token, kv.CreateRevision-1, rec.Keys); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rollbackStaged restores keys to their values at the revision base, and
+// deletes the pending marker, while the marker is owned by token.
+func rollbackStaged(ctx context.Context, backend Backend, marker, token string, base int64, keys []string) error {
+	ops, err := restoreOps(ctx, backend, base, keys)
+	if err != nil {
+		return err
+	}
+
+	owned := []Cmp{cmpValue(marker, "=", token)}
+	size := Config.MaxTxnOps - 1 // room for the marker
+	for ; len(ops) > size; ops = ops[size:] {
+		resp, err := backend.Txn(ctx, owned, ops[:size], nil)
+		if err != nil {
+			return err
+		}
+		if !resp.Succeeded {
+			return fmt.Errorf("%s: '%s'", ErrLostPending, marker)
+		}
+	}
+
+	resp, err := backend.Txn(ctx, owned, append(ops, opDelete(marker)), nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("%s: '%s'", ErrLostPending, marker)
+	}
+	return nil
+}
+
+// restoreOps returns the ops which restore the keys written after the
+// revision base to their values at base.
+func restoreOps(ctx context.Context, backend Backend, base int64, keys []string) ([]Op, error) {
+	var ops []Op
+	for len(keys) != 0 {
+		n := len(keys)
+		if n > Config.MaxTxnOps/2 {
+			n = Config.MaxTxnOps / 2
+		}
+		gets := make([]Op, 0, 2*n)
+		for _, key := range keys[:n] {
+			gets = append(gets, opGet(key, base), opGet(key, 0))
+		}
+		resp, err := backend.Txn(ctx, nil, gets, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys[:n] {
+			old, cur := resp.Responses[2*i].Kvs, resp.Responses[2*i+1].Kvs
+			switch {
+			case len(cur) != 0 && cur[0].ModRevision <= base: // not written
+			case len(old) != 0:
+				ops = append(ops, opPut(key, old[0].Value))
+			case len(cur) != 0:
+				ops = append(ops, opDelete(key))
+			}
+		}
+		keys = keys[n:]
+	}
+	return ops, nil
+}
+
+// opKeys returns the keys of ops.
+func opKeys(ops []Op) []string {
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	return keys
+}
+
+// errRetrySTM restarts a stmTxn at a new revision.
+var errRetrySTM = fmt.Errorf("retry the stm")
+
+// stmWait is returned by an apply function to apply it again after a
+// backoff, err is returned if the context ends first.
+type stmWait struct {
+	err error
+}
+
+func (w stmWait) Error() string {
+	return w.err.Error()
+}
+
+// the backoff of a stmWait
+const (
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+// stmError carries a backend error out of an STM apply function.
+type stmError struct {
+	err error
+}
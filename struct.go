@@ -3,12 +3,14 @@ package setcd
 import (
 	"fmt"
 	"reflect"
-
-	"github.com/coreos/etcd/clientv3"
-	"github.com/fatih/structs"
-	"github.com/mitchellh/mapstructure"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// GetStructVar decodes the dir into the struct which out points to.
+//
+// A field is decoded from the subdir of its 'setcd' tag, see putStruct.
 func (c *Client) GetStructVar(out interface{}, oos ...OpOption) error {
 	outv := reflect.ValueOf(out)
 	if outv.Kind() != reflect.Ptr {
@@ -23,14 +25,208 @@ func (c *Client) GetStructVar(out interface{}, oos ...OpOption) error {
 	if err != nil {
 		return err
 	}
-	if err := mapstructure.Decode(mapv, out); err != nil {
+	return decodeStruct(mapv, out, c.odir)
+}
+
+// decodeStruct decodes a parsed map value of the dir path into the struct
+// which out points to.
+func decodeStruct(in interface{}, out interface{}, path string) error {
+	if _, ok := in.(map[string]interface{}); !ok {
+		return fmt.Errorf("required a map value of struct, but is '%s': '%s'", kindOf(in), path)
+	}
+	return decodeValue(in, reflect.ValueOf(out).Elem(), path)
+}
+
+// putStruct puts the fields of a struct as a map, by the 'setcd' tags:
+//
+//	Field int `setcd:"name,omitempty,inline,string"`
+//
+// name is the subdir of the field, the field name by default, and "-" skips
+// the field. omitempty skips a zero value, inline puts the fields of a struct
+// field into the dir of the struct, and string puts a scale as a string.
+//
+// The fields of embedded structs are inlined unless they are named by a tag,
+// unexported fields and nil pointers are skipped.
+//
+// A field without a 'setcd' tag falls back to its 'structs' tag, and then to
+// its 'mapstructure' tag, where "flatten" and "squash" are inline.
+func (s *STM) putStruct(in interface{}) error {
+	m, err := encodeStruct(reflect.ValueOf(in))
+	if err != nil {
 		return err
 	}
+	return s.putMap(m)
+}
+
+// structField is a field of a struct in the dir.
+type structField struct {
+	name      string
+	index     []int // index sequence of reflect.Value.FieldByIndex
+	omitEmpty bool
+	asString  bool
+}
+
+var structFieldsCache sync.Map // map[reflect.Type][]structField
+
+// structTags are the tag keys of a field by precedence, the tags of
+// fatih/structs and mapstructure put and decode the structs of old versions.
+var structTags = []string{"setcd", "structs", "mapstructure"}
+
+// fieldTag returns the first tag of sf in structTags.
+func fieldTag(sf reflect.StructField) string {
+	for _, key := range structTags {
+		if tag, ok := sf.Tag.Lookup(key); ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+// structFields returns the fields of struct type t, the fields of inlined
+// structs follow the fields of t, and a name is taken by its first field.
+func structFields(t reflect.Type) []structField {
+	if fs, ok := structFieldsCache.Load(t); ok {
+		return fs.([]structField)
+	}
+
+	var fields, inlined []structField
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := fieldTag(sf)
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		inline := hasOpt(opts, "inline") || hasOpt(opts, "flatten") || hasOpt(opts, "squash") ||
+			(sf.Anonymous && name == "")
+		if inline && ft.Kind() == reflect.Struct {
+			for _, f := range structFields(ft) {
+				f.index = append([]int{i}, f.index...)
+				inlined = append(inlined, f)
+			}
+			continue
+		}
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		names[name] = true
+		fields = append(fields, structField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: hasOpt(opts, "omitempty"),
+			asString:  hasOpt(opts, "string"),
+		})
+	}
+	for _, f := range inlined {
+		if !names[f.name] {
+			names[f.name] = true
+			fields = append(fields, f)
+		}
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func hasOpt(opts []string, opt string) bool {
+	for _, o := range opts[1:] {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeStruct converts the struct v into a map by the fields of its type, the
+// values of the fields are kept as they are.
+func encodeStruct(v reflect.Value) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	for _, f := range structFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		if (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface) && fv.IsNil() {
+			continue
+		}
+
+		if !f.asString {
+			ret[f.name] = fv.Interface()
+			continue
+		}
+		sv, err := formatString(reflect.Indirect(fv))
+		if err != nil {
+			return nil, fmt.Errorf("%s: field '%s'", err, f.name)
+		}
+		ret[f.name] = sv
+	}
+	return ret, nil
+}
 
-	return nil
+// fieldByIndex returns the field of v by index, the nil embedded pointers on
+// the way are allocated if alloc, or the field is not found.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
 }
 
-// putStruct ...
-func (c *Client) putStruct(in interface{}) (resp *clientv3.PutResponse, err error) {
-	return c.putMap(structs.New(in).Map())
+// isEmptyValue is the same as the one of encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// formatString formats the scale v as a string.
+func formatString(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.String:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("%s: string of '%s'", ErrUnsupportedType, v.Type())
+	}
 }
@@ -0,0 +1,241 @@
+package setcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag is a named revision with annotations.
+type Tag struct {
+	Name     string    `json:"-"`
+	Revision int64     `json:"rev"`
+	Message  string    `json:"message,omitempty"`
+	Author   string    `json:"author,omitempty"`
+	Created  time.Time `json:"created"`
+	Archive  string    `json:"archive,omitempty"` // key prefix of the archived dir, empty if not archived
+}
+
+func (t *Tag) marshal() (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// parseTag parses a stored tag, a bare revision is a tag of the old format.
+func parseTag(name, val string) (*Tag, error) {
+	if rev, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return &Tag{Name: name, Revision: rev}, nil
+	}
+
+	t := &Tag{Name: name}
+	if err := json.Unmarshal([]byte(val), t); err != nil {
+		return nil, fmt.Errorf("invalid tag '%s': %s", name, err)
+	}
+	return t, nil
+}
+
+func validTagName(name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("%s: tag name '%s'", ErrInvalidArgument, name)
+	}
+	return nil
+}
+
+// Tags returns the tags of the dir sorted by revision, the tags of the
+// ancestors and subdirs are not included.
+func (c *Client) Tags() ([]Tag, error) {
+	tags, err := c.mdGetTags()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Tag, len(tags))
+	for i, t := range tags {
+		ret[i] = *t
+	}
+	return ret, nil
+}
+
+// CreateTag tags the current revision, with the annotations of
+// WithTagMessage and WithTagAuthor. WithTagForce re-points an existing tag.
+func (c *Client) CreateTag(name string, oos ...OpOption) error {
+	if err := validTagName(name); err != nil {
+		return err
+	}
+	opt := parseOption(oos)
+	opt.tag = name
+
+	rev, err := c.backend.Rev(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.mdPutTag(opt, rev)
+}
+
+// DeleteTag deletes the tag and its archive.
+func (c *Client) DeleteTag(name string) error {
+	var t *Tag
+	_, err := runSTM(c.ctx, c.backend, func(stm *stmTxn) error {
+		tagPath, val := c.stmGetTag(stm, name)
+		if val == "" {
+			return fmt.Errorf("%s: '%s'", ErrTagNotFound, name)
+		}
+		var err error
+		if t, err = parseTag(name, val); err != nil {
+			return err
+		}
+		stm.Del(tagPath)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if t.Archive != "" {
+		return c.dropArchive(t.Archive)
+	}
+	return nil
+}
+
+// RenameTag renames the tag, the new name must not exist.
+func (c *Client) RenameTag(name, newName string) error {
+	if err := validTagName(newName); err != nil {
+		return err
+	}
+
+	_, err := runSTM(c.ctx, c.backend, func(stm *stmTxn) error {
+		oldPath, val := c.stmGetTag(stm, name)
+		if val == "" {
+			return fmt.Errorf("%s: '%s'", ErrTagNotFound, name)
+		}
+		if _, nval := c.stmGetTag(stm, newName); nval != "" {
+			return fmt.Errorf("%s: '%s'", ErrTagExists, newName)
+		}
+		stm.Del(oldPath)
+		stm.Put(c.mdGetTagPath(newName), val)
+		return nil
+	})
+	return err
+}
+
+// MoveTag re-points the tag to revision rev, 0 is the current revision. The
+// annotations of the tag are kept, an archived tag is archived again at rev.
+// A future revision is rejected, as is a compacted one of a tag without
+// archive.
+func (c *Client) MoveTag(name string, rev int64) error {
+	cur, err := c.backend.Rev(c.ctx)
+	if err != nil {
+		return err
+	}
+	if rev == 0 {
+		rev = cur
+	}
+	if rev > cur {
+		return fmt.Errorf("%s: %d", ErrFutureRev, rev)
+	}
+
+	var old, archive string
+	_, err = runSTM(c.ctx, c.backend, func(stm *stmTxn) error {
+		tagPath, val := c.stmGetTag(stm, name)
+		if val == "" {
+			return fmt.Errorf("%s: '%s'", ErrTagNotFound, name)
+		}
+		t, err := parseTag(name, val)
+		if err != nil {
+			return err
+		}
+
+		// a tag without archive can not be read at a compacted revision
+		if t.Archive == "" {
+			if err := c.checkRev(rev); err != nil {
+				return err
+			}
+		}
+
+		// the archive at rev is kept for the retries of the txn
+		old = t.Archive
+		if old != "" && archive == "" {
+			if archive, err = c.archive(name, rev); err != nil {
+				return err
+			}
+		}
+		if old != "" {
+			t.Archive = archive
+		}
+		t.Revision = rev
+		val, err = t.marshal()
+		if err != nil {
+			return err
+		}
+		stm.Del(tagPath)
+		stm.Put(c.mdGetTagPath(name), val)
+		return nil
+	})
+	if err != nil {
+		if archive != "" {
+			c.dropArchive(archive)
+		}
+		return err
+	}
+
+	// the archive of the old revision, or the unused one
+	if old == "" {
+		old = archive
+	}
+	if old != "" {
+		return c.dropArchive(old)
+	}
+	return nil
+}
+
+// checkRev checks that the revision rev is not compacted.
+func (c *Client) checkRev(rev int64) error {
+	op := opGet(c.rdir, rev)
+	op.CountOnly = true
+	if _, err := c.backend.Do(c.ctx, op); err != nil {
+		if err == ErrCompacted || err == ErrFutureRev {
+			return fmt.Errorf("%s: %d", err, rev)
+		}
+		return err
+	}
+	return nil
+}
+
+// RetentionPolicy selects the tags to keep on pruning, a tag is kept if any
+// rule keeps it. A zero rule keeps no tag.
+type RetentionPolicy struct {
+	KeepLast int           // keep the last tags by revision
+	MaxAge   time.Duration // keep the tags created within, the tags of the old format are older
+}
+
+// PruneTags deletes the tags of the dir which are not kept by the policy, with
+// their archives, and returns the deleted tags. A zero policy deletes nothing.
+func (c *Client) PruneTags(policy RetentionPolicy) ([]Tag, error) {
+	if policy.KeepLast == 0 && policy.MaxAge == 0 {
+		return nil, nil
+	}
+	tags, err := c.mdGetTags()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var pruned []Tag
+	for i, t := range tags {
+		if len(tags)-i <= policy.KeepLast {
+			break
+		}
+		if policy.MaxAge > 0 && !t.Created.IsZero() && now.Sub(t.Created) <= policy.MaxAge {
+			continue
+		}
+		if err := c.DeleteTag(t.Name); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, *t)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,107 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Tag", func() {
+	var cli *setcd.Client
+
+	BeforeEach(func() {
+		var err error
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), context.Background(), "/Tag")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(map[string]interface{}{"host": "a"},
+			setcd.WithTag("v1"), setcd.WithTagMessage("first release"), setcd.WithTagAuthor("ops"))
+		Expect(err).NotTo(HaveOccurred())
+		err = cli.Put(map[string]interface{}{"host": "b"}, setcd.WithTag("v2"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	names := func() []string {
+		tags, err := cli.Tags()
+		Expect(err).NotTo(HaveOccurred())
+		var ret []string
+		for _, t := range tags {
+			ret = append(ret, t.Name)
+		}
+		return ret
+	}
+
+	Specify("annotations", func() {
+		tags, err := cli.Tags()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(HaveLen(2))
+		Expect(tags[0].Name).To(Equal("v1"))
+		Expect(tags[0].Message).To(Equal("first release"))
+		Expect(tags[0].Author).To(Equal("ops"))
+		Expect(tags[0].Created.IsZero()).To(BeFalse())
+		Expect(tags[0].Revision).To(BeNumerically("<", tags[1].Revision))
+
+		res, err := cli.Get(setcd.WithTagsOnly())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal([]string{"v1", "v2"}))
+	})
+
+	Specify("an existing tag fails the put", func() {
+		err := cli.Put(map[string]interface{}{"host": "c"}, setcd.WithTag("v1"))
+		Expect(err).To(MatchError(ContainSubstring(setcd.ErrTagExists.Error())))
+		Expect(cli.Get()).To(Equal(map[string]interface{}{"host": "b"}))
+
+		err = cli.Put(map[string]interface{}{"host": "c"}, setcd.WithTag("v1"), setcd.WithTagForce())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names()).To(Equal([]string{"v2", "v1"}))
+	})
+
+	Specify("delete, rename and move", func() {
+		Expect(cli.RenameTag("v1", "v2")).NotTo(Succeed())
+		Expect(cli.RenameTag("v1", "release-1")).To(Succeed())
+		Expect(names()).To(Equal([]string{"release-1", "v2"}))
+		Expect(cli.Get(setcd.WithTag("release-1"))).To(Equal(map[string]interface{}{"host": "a"}))
+
+		tags, err := cli.Tags()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cli.MoveTag("v2", tags[0].Revision)).To(Succeed())
+		Expect(cli.MoveTag("v2", 1<<40)).To(MatchError(ContainSubstring(setcd.ErrFutureRev.Error())))
+		Expect(cli.Get(setcd.WithTag("v2"))).To(Equal(map[string]interface{}{"host": "a"}))
+
+		Expect(cli.DeleteTag("release-1")).To(Succeed())
+		Expect(cli.DeleteTag("release-1")).NotTo(Succeed())
+		Expect(names()).To(Equal([]string{"v2"}))
+
+		Expect(cli.CreateTag("v3", setcd.WithTagMessage("current"))).To(Succeed())
+		Expect(cli.Get(setcd.WithTag("v3"))).To(Equal(map[string]interface{}{"host": "b"}))
+	})
+
+	Specify("scoped to subtrees", func() {
+		a, err := cli.ShadowClone("service-a")
+		Expect(err).NotTo(HaveOccurred())
+		b, err := cli.ShadowClone("service-b")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(a.Put("alpha", setcd.WithTag("v1"))).To(Succeed())
+		Expect(b.Put("beta", setcd.WithTag("v1"))).To(Succeed())
+		Expect(a.Put("gamma")).To(Succeed())
+		Expect(a.Get(setcd.WithTag("v1"))).To(Equal("alpha"))
+		Expect(b.Get(setcd.WithTag("v1"))).To(Equal("beta"))
+		Expect(names()).To(Equal([]string{"v1", "v2"}))
+
+		// resolved from the nearest tagged ancestor
+		host, err := cli.ShadowClone("host")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(host.Get(setcd.WithTag("v1"))).To(Equal("a"))
+		_, err = host.Get(setcd.WithTag("v3"))
+		Expect(err).To(MatchError(ContainSubstring(setcd.ErrTagNotFound.Error())))
+	})
+})
@@ -0,0 +1,135 @@
+package setcd
+
+import (
+	"golang.org/x/net/context"
+)
+
+// WatchEvent is a change of a watched dir.
+type WatchEvent struct {
+	Revision int64       // revision of the committed change
+	Value    interface{} // value of the dir at Revision
+	Changes  []Change    // changes from the value of the previous event
+	Err      error       // set when the value can not be read; fatal if the watch is broken
+}
+
+// Watch watches the dir and its metadata.
+//
+// An event is sent for every committed revision which changes the value of
+// the dir. Revisions are coalesced when the receiver is slow, so an event
+// may carry the changes of several revisions. The returned channel is closed
+// when ctx is done or the watch is broken.
+func (c *Client) Watch(ctx context.Context, oos ...OpOption) <-chan WatchEvent {
+	opt := parseOption(oos)
+
+	// the watch starts from the current revision when it is called
+	ch := make(chan WatchEvent)
+	rev, err := c.backend.Rev(ctx)
+	if err != nil {
+		go c.watchFail(ctx, ch, WatchEvent{Err: err})
+		return ch
+	}
+	prev, err := c.atRev(rev).get(opt)
+	if err != nil {
+		go c.watchFail(ctx, ch, WatchEvent{Revision: rev, Err: err})
+		return ch
+	}
+
+	go c.watch(ctx, opt, rev, prev, ch)
+	return ch
+}
+
+// watchFail sends the event of a failed watch.
+func (c *Client) watchFail(ctx context.Context, ch chan<- WatchEvent, ev WatchEvent) {
+	defer close(ch)
+	select {
+	case ch <- ev:
+	case <-ctx.Done():
+	}
+}
+
+// watch watches the changes after revision rev, prev is the value at rev.
+func (c *Client) watch(ctx context.Context, opt *Option, rev int64, prev interface{}, ch chan<- WatchEvent) {
+	defer close(ch)
+
+	send := func(ev WatchEvent) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// merge the watches of data, metadata and the pending markers, the last
+	// chunk of a staged write deletes its marker
+	ranges := [][2]string{
+		{c.rdir, prefixEnd(c.rdir)},
+		{c.mdir, prefixEnd(c.mdir)},
+		{pendingKey(c.rdir), prefixEnd(pendingKey(c.rdir))},
+	}
+	for _, akey := range pendingAncestors(c.rdir) {
+		ranges = append(ranges, [2]string{akey, ""})
+	}
+	resps := make(chan WatchResponse)
+	for _, r := range ranges {
+		wch := c.backend.Watch(wctx, r[0], r[1], rev+1)
+		go func() {
+			for resp := range wch {
+				select {
+				case resps <- resp:
+				case <-wctx.Done():
+					return
+				}
+			}
+			select {
+			case resps <- WatchResponse{Err: ErrWatchClosed}:
+			case <-wctx.Done():
+			}
+		}()
+	}
+
+	for {
+		var resp WatchResponse
+		select {
+		case resp = <-resps:
+		case <-ctx.Done():
+			return
+		}
+
+		if resp.Err != nil {
+			send(WatchEvent{Revision: resp.Revision, Err: resp.Err})
+			return
+		}
+		if len(resp.Events) == 0 {
+			continue
+		}
+
+		// the value at the latest revision covers all the earlier events
+		erev := resp.Events[len(resp.Events)-1].Kv.ModRevision
+		if erev <= rev {
+			continue
+		}
+		rev = erev
+
+		val, err := c.atRev(rev).get(opt)
+		if err != nil {
+			if !send(WatchEvent{Revision: rev, Err: err}) {
+				return
+			}
+			continue
+		}
+
+		changes := diffValue(c.odir, prev, val)
+		if len(changes) == 0 {
+			continue
+		}
+		prev = val
+
+		if !send(WatchEvent{Revision: rev, Value: val, Changes: changes}) {
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package setcd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+
+	"github.com/helloyi/setcd"
+)
+
+var _ = Describe("Watch", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		cli    *setcd.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx, cancel = context.WithCancel(context.Background())
+		cli, err = setcd.NewWithBackend(setcd.NewMemoryBackend(), ctx, "/Watch")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = cli.Put(map[string]interface{}{"host": "a", "scheme": "http"}, setcd.WithLock())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+		err := cli.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Specify("structured changes", func() {
+		wch := cli.Watch(ctx)
+
+		err := cli.Put(map[string]interface{}{"scheme": "https", "tls": "on"}, setcd.WithLock())
+		Expect(err).NotTo(HaveOccurred())
+
+		ev := <-wch
+		Expect(ev.Err).NotTo(HaveOccurred())
+		Expect(ev.Value).To(Equal(map[string]interface{}{"host": "a", "scheme": "https", "tls": "on"}))
+		Expect(ev.Changes).To(Equal([]setcd.Change{
+			{Path: "/Watch/scheme/", Type: setcd.Modified, Old: "http", New: "https"},
+			{Path: "/Watch/tls/", Type: setcd.Added, New: "on"},
+		}))
+	})
+})